@@ -0,0 +1,182 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// archiveDirName is the folder in the cacheDir holding previous certificates, see Config.KeepPreviousCerts
+const archiveDirName = "archive"
+
+// ErrNoArchivedCert is returned by RollbackCert if there is no previous cert in the archive
+var ErrNoArchivedCert = errors.New("simplecert: no archived certificate to roll back to")
+
+// archivedFiles returns the files of a certificate set that are archived
+func archivedFiles() []string {
+	return []string{c.certResourceFile(), c.certFile(), c.keyFile(), combinedPEMFileName}
+}
+
+// archiveCert moves a copy of the current cert, key and cert resource into cacheDir/archive/<timestamp>
+// and prunes the archive to the last c.KeepPreviousCerts entries
+// the caller must hold diskMu
+func archiveCert(cacheDir string) error {
+	if c.KeepPreviousCerts <= 0 {
+		return nil
+	}
+
+	// nothing to archive yet
+	if _, err := os.Stat(filepath.Join(cacheDir, c.certFile())); err != nil {
+		return nil
+	}
+
+	// the timestamp format sorts lexically
+	dir := filepath.Join(cacheDir, archiveDirName, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	err := os.MkdirAll(dir, c.CacheDirPerm)
+	if err != nil {
+		return fmt.Errorf("failed to create archive dir: %w", err)
+	}
+
+	for _, name := range archivedFiles() {
+		err = copyFile(filepath.Join(cacheDir, name), filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to archive %s: %w", name, err)
+		}
+	}
+
+	log.Println("[INFO] simplecert: archived previous cert in", dir)
+
+	// prune the oldest entries
+	entries, err := archiveEntries(cacheDir)
+	if err != nil {
+		return err
+	}
+	for len(entries) > c.KeepPreviousCerts {
+		err = os.RemoveAll(filepath.Join(cacheDir, archiveDirName, entries[0]))
+		if err != nil {
+			return fmt.Errorf("failed to prune archive: %w", err)
+		}
+		entries = entries[1:]
+	}
+
+	return nil
+}
+
+// archiveEntries returns the names of the archived cert sets, oldest first
+func archiveEntries(cacheDir string) ([]string, error) {
+	files, err := os.ReadDir(filepath.Join(cacheDir, archiveDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read archive dir: %w", err)
+	}
+
+	var names []string
+	for _, f := range files {
+		if f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// RollbackCert restores the most recently archived cert of cfg.
+// The restored set is removed from the archive, so calling RollbackCert again goes back further.
+// Requires Config.KeepPreviousCerts to be set when the cert was replaced.
+// If a CertReloader for the cert has been started in this process, it reloads the restored cert.
+// Otherwise, e.g. when called from a maintenance tool, the running service must be reloaded,
+// for example by sending it the ReloadSignal.
+func RollbackCert(cfg *Config) error {
+	// apply environment overrides and validate config
+	applyEnv(cfg)
+	err := CheckConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// update global config
+	lockConfig()
+	defer unlockConfig()
+	setConfig(cfg)
+
+	// acquire the cacheDir lock, so the cert is not renewed concurrently
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
+	entries, err := archiveEntries(c.CacheDir)
+	if err != nil {
+		return fmt.Errorf("simplecert: %w", err)
+	}
+	if len(entries) == 0 {
+		return ErrNoArchivedCert
+	}
+
+	dir := filepath.Join(c.CacheDir, archiveDirName, entries[len(entries)-1])
+
+	// copy the archived files next to their destination first, and rename them into place at once,
+	// so a failing copy never leaves the restored key next to the current cert
+	var staged []string
+	defer func() {
+		for _, name := range staged {
+			os.Remove(stagedPath(c.CacheDir, name))
+		}
+	}()
+	for _, name := range archivedFiles() {
+		err = copyFile(filepath.Join(dir, name), stagedPath(c.CacheDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("simplecert: failed to restore %s: %w", name, err)
+		}
+		staged = append(staged, name)
+	}
+
+	diskMu.Lock()
+	for len(staged) > 0 {
+		err = os.Rename(stagedPath(c.CacheDir, staged[0]), filepath.Join(c.CacheDir, staged[0]))
+		if err != nil {
+			diskMu.Unlock()
+			return fmt.Errorf("simplecert: failed to restore %s: %w", staged[0], err)
+		}
+		staged = staged[1:]
+	}
+	diskMu.Unlock()
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to remove restored cert from archive: %w", err)
+	}
+
+	log.Println("[INFO] simplecert: rolled back to cert archived in", dir)
+
+	// signaling a process without a CertReloader could terminate it
+	reloader := startedReloader(filepath.Join(c.CacheDir, c.certFile()))
+	if reloader == nil {
+		log.Println("[INFO] simplecert: no CertReloader for", c.CacheDir, "in this process, reload the running service to load the restored cert")
+		return nil
+	}
+
+	reloader.ReloadNow()
+	return nil
+}
+
+// stagedPath returns the path a file restored by RollbackCert is copied to, before it is renamed into place
+func stagedPath(cacheDir, name string) string {
+	return filepath.Join(cacheDir, "."+name+".rollback")
+}
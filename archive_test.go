@@ -0,0 +1,103 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollbackCert(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CacheDir = t.TempDir()
+	cfg.KeepPreviousCerts = 1
+	cfg.FailedToRenewCertificate = func(error) {}
+	defer setConfig(nil)
+
+	previous, current := testCertResource(t, time.Hour), testCertResource(t, 2*time.Hour)
+	configMu.Lock()
+	setConfig(&cfg)
+	err := saveCertToDisk(previous, cfg.CacheDir)
+	if err == nil {
+		err = saveCertToDisk(current, cfg.CacheDir)
+	}
+	configMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		certPath = filepath.Join(cfg.CacheDir, certFileName)
+		keyPath  = filepath.Join(cfg.CacheDir, keyFileName)
+	)
+	reloader := newCertReloader()
+	reloader.cfg = &cfg
+	if err := reloader.start(certPath, keyPath, nil, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// the reloader started in this process picks up the restored cert
+	if err := RollbackCert(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reloader.NotAfter().Equal(certNotAfter(previous)) {
+		t.Fatalf("expected the previous cert to be served, got one expiring at %s", reloader.NotAfter())
+	}
+	if err := checkKeyPair(certPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// no files are left behind
+	files, err := filepath.Glob(filepath.Join(cfg.CacheDir, ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Fatalf("unexpected files in cacheDir: %v", files)
+	}
+
+	if err := RollbackCert(&cfg); err != ErrNoArchivedCert {
+		t.Fatalf("got %v, want %v", err, ErrNoArchivedCert)
+	}
+}
+
+func TestRollbackCertWithoutReloader(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CacheDir = t.TempDir()
+	cfg.KeepPreviousCerts = 1
+	cfg.FailedToRenewCertificate = func(error) {}
+	defer setConfig(nil)
+
+	previous := testCertResource(t, time.Hour)
+	configMu.Lock()
+	setConfig(&cfg)
+	err := saveCertToDisk(previous, cfg.CacheDir)
+	if err == nil {
+		err = saveCertToDisk(testCertResource(t, 2*time.Hour), cfg.CacheDir)
+	}
+	configMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the test binary has no handler for the reload signal, sending it would terminate the test
+	if err := RollbackCert(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	notAfter, err := certFileNotAfter(filepath.Join(cfg.CacheDir, certFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notAfter.Equal(certNotAfter(previous)) {
+		t.Fatal("expected the previous cert to be restored")
+	}
+}
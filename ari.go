@@ -0,0 +1,50 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/x509"
+	"log"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// checkARI fetches the suggested renewal window (ACME Renewal Information) for cert and reports whether it should be renewed now.
+// if the renewal is due before the next regular check, its time is returned as well,
+// so the renewal routine can wake up in time.
+func checkARI(client acmeClient, cert *x509.Certificate) (due bool, renewAt time.Time, err error) {
+	info, err := client.GetRenewalInfo(certificate.RenewalInfoRequest{Cert: cert})
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	var (
+		now    = time.Now()
+		window = info.SuggestedWindow
+	)
+	log.Println("[INFO] simplecert: ARI suggested renewal window:", window.Start.Format(time.RFC1123), "-", window.End.Format(time.RFC1123))
+
+	// ShouldRenewAt picks a random time inside the window and can not handle empty windows
+	var suggested *time.Time
+	if window.End.After(window.Start) {
+		suggested = info.ShouldRenewAt(now, c.CheckInterval)
+	} else if start := window.Start; !start.After(now.Add(c.CheckInterval)) {
+		suggested = &start
+	}
+
+	// not due before the next check
+	if suggested == nil {
+		return false, time.Time{}, nil
+	}
+
+	if suggested.After(now) {
+		log.Println("[INFO] simplecert: scheduling renewal at", suggested.Format(time.RFC1123))
+		return false, *suggested, nil
+	}
+
+	return true, time.Time{}, nil
+}
@@ -9,13 +9,19 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/go-acme/lego/v4/certificate"
 	"github.com/sugawarayuuta/sonnet"
 )
 
+// diskMu serializes writing the cert and key in the cacheDir with reloading them
+var diskMu sync.Mutex
+
 // parsePEMBundle parses a certificate bundle from top to bottom and returns
 // a slice of x509 certificates. This function will error if no certificates are found.
 func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
@@ -46,26 +52,80 @@ func parsePEMBundle(bundle []byte) ([]*x509.Certificate, error) {
 	return certificates, nil
 }
 
+// checkPreferredChain logs a warning if the preferred chain has been configured
+// but the CA did not offer a matching chain and the default chain was used.
+// The chain matches if the topmost certificate was issued by the preferred chain's common name.
+func checkPreferredChain(cert *certificate.Resource) {
+	if c.PreferredChain == "" {
+		return
+	}
+
+	certs, err := parsePEMBundle(cert.Certificate)
+	if err != nil {
+		log.Println("[WARNING] simplecert: failed to parse cert for checking the preferred chain: ", err)
+		return
+	}
+
+	if top := certs[len(certs)-1]; top.Issuer.CommonName != c.PreferredChain {
+		log.Printf("[WARNING] simplecert: no chain matching the preferred chain %q was offered by the CA, using the default chain issued by %q", c.PreferredChain, top.Issuer.CommonName)
+	}
+}
+
 // cert exists in cacheDir?
 func certCached(cacheDir string) bool {
-	_, errCert := os.Stat(filepath.Join(cacheDir, certFileName))
-	_, errKey := os.Stat(filepath.Join(cacheDir, keyFileName))
+	_, errCert := os.Stat(filepath.Join(cacheDir, c.certFile()))
+	_, errKey := os.Stat(filepath.Join(cacheDir, c.keyFile()))
 	if errCert == nil && errKey == nil {
 		return true
 	}
 	return false
 }
 
-// Persist the certificate on disk
-// this assumes that cacheDir exists
-func saveCertToDisk(cert *certificate.Resource, cacheDir string) error {
+// checkKeyPair verifies that the cert and key can be loaded
+// and that the private key matches the public key of the certificate
+func checkKeyPair(certPath, keyPath string) error {
+	_, err := loadKeyPair(c, certPath, keyPath)
+	return err
+}
+
+// readCertResource loads the ACME certificate resource stored at path,
+// decrypting the key with the KeyEncryptionPassphrase of cfg if necessary
+func readCertResource(cfg *Config, path string) (*certificate.Resource, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate resource from disk: %w", err)
+	}
+
+	// unmarshal certificate resource
+	var cr CR
+	err = sonnet.Unmarshal(b, &cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal certificate resource: %w", err)
+	}
+
+	// the key is kept decrypted in memory
+	cr.PrivateKey, err = cfg.openKey(cr.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return getACMECertResource(cr), nil
+}
+
+// writeCertResource writes the ACME certificate resource to cacheDir
+func writeCertResource(cert *certificate.Resource, cacheDir string) error {
+	keyPEM, err := c.sealKey(cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+
 	// JSON encode certificate resource
 	// needs to be a CR otherwise the fields with the keys will be lost
 	b, err := sonnet.MarshalIndent(CR{
 		Domain:            cert.Domain,
 		CertURL:           cert.CertURL,
 		CertStableURL:     cert.CertStableURL,
-		PrivateKey:        cert.PrivateKey,
+		PrivateKey:        keyPEM,
 		Certificate:       cert.Certificate,
 		IssuerCertificate: cert.IssuerCertificate,
 		CSR:               cert.CSR,
@@ -74,23 +134,138 @@ func saveCertToDisk(cert *certificate.Resource, cacheDir string) error {
 		return err
 	}
 
-	// write certificate resource to disk
-	err = os.WriteFile(filepath.Join(cacheDir, certResourceFileName), b, c.CacheDirPerm)
+	return writeFileAtomic(filepath.Join(cacheDir, c.certResourceFile()), b, c.CacheDirPerm)
+}
+
+// restoreCertResource rebuilds the certificate resource from the cert and key PEM files,
+// e.g. if CertResource.json is corrupt, and writes it to cacheDir.
+// The URLs of the cert are lost, they are not needed for renewing it.
+func restoreCertResource(certPath, keyPath, cacheDir string) (*certificate.Resource, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err = c.openKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := parsePEMBundle(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	// the issuer chain follows the leaf in the bundle
+	var issuer []byte
+	for _, cert := range certs[1:] {
+		issuer = append(issuer, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	domain := certs[0].Subject.CommonName
+	if names := certNames(certs[0]); domain == "" && len(names) > 0 {
+		domain = names[0]
+	}
+
+	cert := &certificate.Resource{
+		Domain:            domain,
+		PrivateKey:        keyPEM,
+		Certificate:       certPEM,
+		IssuerCertificate: issuer,
+	}
+
+	err = writeCertResource(cert, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// notifyCertChanged passes the new cert and key to the OnCertChanged handler and logs its error
+func notifyCertChanged(cert *certificate.Resource) {
+	handler := c.OnCertChanged
+	if handler == nil {
+		return
+	}
+
+	certPEM, keyPEM := cert.Certificate, cert.PrivateKey
+	afterConfigUnlock(func() {
+		err := handler(certPEM, keyPEM)
+		if err != nil {
+			log.Println("[ERROR] simplecert: OnCertChanged handler failed: ", err)
+		}
+	})
+}
+
+// Persist the certificate on disk
+// this assumes that cacheDir exists
+// every file is written atomically, so readers never observe a partially written cert or key
+// diskMu is held while writing, so the reloader never reads a cert that does not match the key
+func saveCertToDisk(cert *certificate.Resource, cacheDir string) error {
+	diskMu.Lock()
+	defer diskMu.Unlock()
+
+	// keep a copy of the current cert
+	err := archiveCert(cacheDir)
 	if err != nil {
 		return err
 	}
 
+	// the cert and key are written first, so a failing export never leaves
+	// the new cert next to the old key in the cacheDir
+	// certificates obtained for a CSR do not come with a private key
+	if len(cert.PrivateKey) > 0 {
+		// write private key PEM to disk, encrypted if a KeyEncryptionPassphrase is configured
+		keyPEM, err := c.sealKey(cert.PrivateKey)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(filepath.Join(cacheDir, c.keyFile()), keyPEM, c.CacheDirPerm)
+		if err != nil {
+			return err
+		}
+	}
+
 	// write certificate PEM to disk
-	err = os.WriteFile(filepath.Join(cacheDir, certFileName), cert.Certificate, c.CacheDirPerm)
+	err = writeFileAtomic(filepath.Join(cacheDir, c.certFile()), cert.Certificate, c.CacheDirPerm)
+	if err != nil {
+		return err
+	}
+
+	// write certificate resource to disk
+	err = writeCertResource(cert, cacheDir)
 	if err != nil {
 		return err
 	}
 
-	// write private key PEM to disk
-	err = os.WriteFile(filepath.Join(cacheDir, keyFileName), cert.PrivateKey, c.CacheDirPerm)
+	// write additional output files
+	err = writeOutputFiles(cert)
 	if err != nil {
 		return err
 	}
 
+	// write combined PEM if requested
+	err = writeCombinedPEM(cert, cacheDir)
+	if err != nil {
+		return err
+	}
+
+	// write PKCS#12 archives if requested
+	if c.WritePKCS12 {
+		err = writePKCS12(cert.Certificate, cert.PrivateKey, filepath.Join(cacheDir, pkcs12FileName))
+		if err != nil {
+			return err
+		}
+	}
+	if c.PKCS12Path != "" {
+		err = writePKCS12(cert.Certificate, cert.PrivateKey, c.PKCS12Path)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,66 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveCertToDiskExportFails(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(cfg *Config, unwritable string)
+	}{
+		{"pkcs12", func(cfg *Config, unwritable string) { cfg.PKCS12Path = unwritable }},
+		{"output file", func(cfg *Config, unwritable string) { cfg.OutputFiles = map[string]string{"fullchain": unwritable} }},
+		{"combined pem", func(cfg *Config, unwritable string) {
+			// a directory can not be replaced by the combined PEM
+			cfg.WriteCombinedPEM = true
+			os.Mkdir(filepath.Join(cfg.CacheDir, combinedPEMFileName), 0700)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			setConfig(&Config{CacheDir: dir, CacheDirPerm: 0700})
+			defer setConfig(nil)
+
+			if err := saveCertToDisk(testCertResource(t, time.Hour), dir); err != nil {
+				t.Fatal(err)
+			}
+
+			// a path below a regular file can not be written, not even by root
+			blocker := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(blocker, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			tt.configure(c, filepath.Join(blocker, "export"))
+
+			cert := testCertResource(t, 2*time.Hour)
+			if err := saveCertToDisk(cert, dir); err == nil {
+				t.Fatal("expected the export to fail")
+			}
+
+			// the new cert and key are in place and match
+			certPath, keyPath := filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName)
+			if err := checkKeyPair(certPath, keyPath); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(certPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, cert.Certificate) {
+				t.Fatal("expected the new cert in the cacheDir")
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-acme/lego/v4/challenge/http01"
+	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
+)
+
+// withChallengeServers runs fn with retries while the standalone challenge servers may bind their ports.
+// BeforeChallengeBind and AfterChallengeRelease are called around it, so the ports can be handed over.
+// The timings of the successful attempt are reported to OnChallengeTimings.
+func withChallengeServers(action string, fn func() error) error {
+	fn = withChallengeTimings(action, fn)
+	if c.HTTPAddress == "" && c.TLSAddress == "" {
+		return withRetry(action, fn)
+	}
+
+	if c.BeforeChallengeBind != nil {
+		err := c.BeforeChallengeBind()
+		if err != nil {
+			return fmt.Errorf("simplecert: BeforeChallengeBind failed: %w", err)
+		}
+	}
+	if c.AfterChallengeRelease != nil {
+		defer c.AfterChallengeRelease()
+	}
+
+	return withRetry(action, fn)
+}
+
+// challengeProvider serves the HTTP challenges from memory, see ChallengeHandler
+var challengeProvider = &memoryProvider{
+	tokens: make(map[string]string),
+}
+
+// ChallengeHandler returns a http.Handler serving the ACME HTTP challenge,
+// to be mounted on an existing server listening on port 80 when Config.HTTPChallengeHandler is set:
+//
+//	mux.Handle("/.well-known/acme-challenge/", simplecert.ChallengeHandler())
+//
+// The server must be running before calling Init, since the challenge is solved during Init.
+func ChallengeHandler() http.Handler {
+	return challengeProvider
+}
+
+// ChallengeMiddleware serves the ACME HTTP challenge like ChallengeHandler
+// and passes all other requests to next, e.g. to redirect them to HTTPS:
+//
+//	http.ListenAndServe(":80", simplecert.ChallengeMiddleware(http.HandlerFunc(simplecert.Redirect)))
+//
+// Requires Config.HTTPChallengeHandler to be set.
+func ChallengeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, http01.ChallengePath("")) {
+			challengeProvider.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// httpNetworkServer is a standalone HTTP challenge server listening on a specific network,
+// the server of lego always listens on tcp
+type httpNetworkServer struct {
+	network  string
+	addr     string
+	provider *memoryProvider
+	listener net.Listener
+}
+
+// GetAddress returns the address the server binds
+func (s *httpNetworkServer) GetAddress() string {
+	return s.addr
+}
+
+// Present starts the server and serves the key authorization for the token
+func (s *httpNetworkServer) Present(domain, token, keyAuth string) error {
+	var err error
+	s.listener, err = net.Listen(s.network, s.addr)
+	if err != nil {
+		return fmt.Errorf("could not start HTTP server for challenge: %w", err)
+	}
+
+	s.provider.Present(domain, token, keyAuth)
+	go serveChallenge(s.listener, s.provider)
+
+	return nil
+}
+
+// CleanUp stops the server
+func (s *httpNetworkServer) CleanUp(domain, token, keyAuth string) error {
+	s.provider.CleanUp(domain, token, keyAuth)
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// tlsNetworkServer is a standalone TLS-ALPN challenge server listening on a specific network,
+// the server of lego always listens on tcp
+type tlsNetworkServer struct {
+	network  string
+	addr     string
+	listener net.Listener
+}
+
+// GetAddress returns the address the server binds
+func (s *tlsNetworkServer) GetAddress() string {
+	return s.addr
+}
+
+// Present starts the server with a challenge certificate for the key authorization
+func (s *tlsNetworkServer) Present(domain, token, keyAuth string) error {
+	cert, err := tlsalpn01.ChallengeCert(domain, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	s.listener, err = tls.Listen(s.network, s.addr, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{tlsalpn01.ACMETLS1Protocol},
+	})
+	if err != nil {
+		return fmt.Errorf("could not start HTTPS server for challenge: %w", err)
+	}
+
+	go serveChallenge(s.listener, http.NotFoundHandler())
+
+	return nil
+}
+
+// CleanUp stops the server
+func (s *tlsNetworkServer) CleanUp(domain, token, keyAuth string) error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// serveChallenge serves handler on l until l is closed
+func serveChallenge(l net.Listener, handler http.Handler) {
+	err := http.Serve(l, handler)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println("[ERROR] simplecert: challenge server failed: ", err)
+	}
+}
+
+// memoryProvider implements the lego challenge.Provider interface
+// and serves the key authorizations of pending challenges via HTTP
+type memoryProvider struct {
+	sync.RWMutex
+	tokens map[string]string
+}
+
+// Present makes the key authorization for the token available
+func (p *memoryProvider) Present(domain, token, keyAuth string) error {
+	p.Lock()
+	p.tokens[token] = keyAuth
+	p.Unlock()
+	return nil
+}
+
+// CleanUp removes the token once the challenge is done
+func (p *memoryProvider) CleanUp(domain, token, keyAuth string) error {
+	p.Lock()
+	delete(p.tokens, token)
+	p.Unlock()
+	return nil
+}
+
+func (p *memoryProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, http01.ChallengePath(""))
+	if !ok || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.RLock()
+	keyAuth, ok := p.tokens[token]
+	p.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
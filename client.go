@@ -9,14 +9,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"net"
 
 	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/challenge/http01"
 	"github.com/go-acme/lego/v4/challenge/tlsalpn01"
 	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/providers/dns"
+	"github.com/go-acme/lego/v4/providers/http/webroot"
 	"github.com/go-acme/lego/v4/registration"
 )
 
@@ -25,15 +27,33 @@ import (
  */
 
 func createClient(u SSLUser, dnsServers []string) (lego.Client, error) {
+	// use the configured account instead of registering a new one
+	// the registration must be set before creating the client, the account URI is used as key ID
+	queryAccount := c.AccountURI != "" && (u.Registration == nil || u.Registration.URI != c.AccountURI)
+	if queryAccount {
+		u.Registration = &registration.Resource{URI: c.AccountURI}
+	}
+
 	// create lego config
 	config := lego.NewConfig(&u)
 	config.CADirURL = c.DirectoryURL
 	config.Certificate.KeyType = certcrypto.KeyType(c.KeyType)
+	if c.UserAgent != "" {
+		config.UserAgent = c.UserAgent
+	}
+	if c.HTTPClient != nil {
+		config.HTTPClient = c.HTTPClient
+	}
+
+	// the requests of an obtain fail once the ObtainTimeout has passed, lego can not be canceled otherwise
+	if !obtainUntil.IsZero() {
+		config.HTTPClient = deadlineClient(config.HTTPClient, obtainUntil)
+	}
 
 	// Create a new client instance
 	client, err := lego.NewClient(config)
 	if err != nil {
-		return lego.Client{}, fmt.Errorf("simplecert: failed to create client: %s", err)
+		return lego.Client{}, fmt.Errorf("simplecert: failed to create client: %w", err)
 	}
 
 	log.Println("[INFO] simplecert: client creation complete")
@@ -42,15 +62,20 @@ func createClient(u SSLUser, dnsServers []string) (lego.Client, error) {
 	// DNS Challenge
 	// -------------------------------------------
 
-	if c.DNSProvider != "" {
-		p, err := dns.NewDNSChallengeProviderByName(c.DNSProvider)
+	if c.usesDNSChallenge() {
+		p, err := newDNSProvider()
 		if err != nil {
-			return *client, fmt.Errorf("simplecert: setting DNS provider specified in config: %s", err)
+			return *client, fmt.Errorf("simplecert: setting DNS provider specified in config: %w", err)
 		}
 
-		err = client.Challenge.SetDNS01Provider(p, dns01.CondOption((len(dnsServers) > 0), dns01.AddRecursiveNameservers(dns01.ParseNameservers(dnsServers))))
+		p = timeChallenges(withDNSTimeouts(withCNAMEDelegation(p)))
+		err = client.Challenge.SetDNS01Provider(p,
+			dns01.CondOption((len(dnsServers) > 0), dns01.AddRecursiveNameservers(dns01.ParseNameservers(dnsServers))),
+			dns01.CondOption(c.DisableDNSPropagationCheck, dns01.WrapPreCheck(skipPropagationCheck)),
+			dns01.CondOption(!c.DisableDNSPropagationCheck && c.DNSChallengeRetries > 0, dns01.WrapPreCheck(retryPropagationCheck(p, c.DNSChallengeRetries))),
+		)
 		if err != nil {
-			return *client, fmt.Errorf("simplecert: setting DNS challenge provider failed: %s", err)
+			return *client, fmt.Errorf("simplecert: setting DNS challenge provider failed: %w", err)
 		}
 
 		log.Println("[INFO] simplecert: set DNS challenge")
@@ -60,17 +85,35 @@ func createClient(u SSLUser, dnsServers []string) (lego.Client, error) {
 	// HTTP Challenges
 	// -------------------------------------------
 
-	if c.HTTPAddress != "" {
-		httpSlice := strings.Split(c.HTTPAddress, ":")
-		if len(httpSlice) != 2 {
-			return *client, fmt.Errorf("simplecert: invalid HTTP address: %s", c.HTTPAddress)
+	if c.WebRoot != "" {
+		p, err := webroot.NewHTTPProvider(c.WebRoot)
+		if err != nil {
+			return *client, fmt.Errorf("simplecert: setting webroot provider failed: %w", err)
+		}
+		err = client.Challenge.SetHTTP01Provider(timeChallenges(p))
+		if err != nil {
+			return *client, fmt.Errorf("simplecert: setting HTTP challenge provider failed: %w", err)
+		}
+
+		log.Println("[INFO] simplecert: set HTTP challenge using webroot", c.WebRoot)
+	} else if c.HTTPChallengeHandler {
+		err = client.Challenge.SetHTTP01Provider(timeChallenges(challengeProvider))
+		if err != nil {
+			return *client, fmt.Errorf("simplecert: setting HTTP challenge provider failed: %w", err)
+		}
+
+		log.Println("[INFO] simplecert: set HTTP challenge using the ChallengeHandler")
+	} else if c.HTTPAddress != "" {
+		p, err := newHTTPChallengeServer(c.ChallengeNetwork, c.HTTPAddress)
+		if err != nil {
+			return *client, err
 		}
-		err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer(httpSlice[0], httpSlice[1]))
+		err = client.Challenge.SetHTTP01Provider(timeChallenges(p))
 		if err != nil {
-			return *client, fmt.Errorf("simplecert: setting HTTP challenge provider failed: %s", err)
+			return *client, fmt.Errorf("simplecert: setting HTTP challenge provider failed: %w", err)
 		}
 
-		log.Println("[INFO] simplecert: set HTTP challenge")
+		log.Println("[INFO] simplecert: set HTTP challenge on", p.GetAddress())
 	}
 
 	// -------------------------------------------
@@ -78,33 +121,116 @@ func createClient(u SSLUser, dnsServers []string) (lego.Client, error) {
 	// -------------------------------------------
 
 	if c.TLSAddress != "" {
-		tlsSlice := strings.Split(c.TLSAddress, ":")
-		if len(tlsSlice) != 2 {
-			return *client, fmt.Errorf("simplecert: invalid TLS address: %s", c.TLSAddress)
+		p, err := newTLSChallengeServer(c.ChallengeNetwork, c.TLSAddress)
+		if err != nil {
+			return *client, err
 		}
-		err = client.Challenge.SetTLSALPN01Provider(tlsalpn01.NewProviderServer(tlsSlice[0], tlsSlice[1]))
+		err = client.Challenge.SetTLSALPN01Provider(timeChallenges(p))
 		if err != nil {
-			return *client, fmt.Errorf("simplecert: setting TLS challenge provider failed: %s", err)
+			return *client, fmt.Errorf("simplecert: setting TLS challenge provider failed: %w", err)
 		}
 
-		log.Println("[INFO] simplecert: set TLS challenge")
+		log.Println("[INFO] simplecert: set TLS challenge on", p.GetAddress())
+	}
+
+	if !c.usesDNSChallenge() && c.TLSAddress == "" && c.HTTPAddress == "" && c.WebRoot == "" && !c.HTTPChallengeHandler {
+		return *client, errors.New("simplecert: you must specify at least one of the challenge types: dns, http, webroot, challenge handler or tls")
 	}
 
-	if c.DNSProvider == "" && c.TLSAddress == "" && c.HTTPAddress == "" {
-		return *client, errors.New("simplecert: you must specify at least one of the challenge types: dns, http or tls")
+	// the account key must belong to the configured account
+	if queryAccount {
+		reg, err := client.Registration.QueryRegistration()
+		if err != nil {
+			return *client, fmt.Errorf("simplecert: failed to query account %s: %w", c.AccountURI, err)
+		}
+		u.Registration = reg
+		log.Println("[INFO] simplecert: using existing account", c.AccountURI)
+		saveUserToDisk(u, c.accountDir())
 	}
 
 	// register if necessary
 	if u.Registration == nil {
-		// Register Client and agree to TOS
-		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		// Register Client and agree to TOS, unless disabled
+		agree := c.AgreeToS == nil || *c.AgreeToS
+		if !agree {
+			log.Println("[INFO] simplecert: registering without agreeing to the terms of service")
+		}
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: agree})
 		if err != nil {
-			return *client, fmt.Errorf("simplecert: failed to register client: %s", err)
+			return *client, fmt.Errorf("simplecert: failed to register client: %w", err)
 		}
 		u.Registration = reg
 		log.Println("[INFO] simplecert: client registration complete: ", client)
-		saveUserToDisk(u, c.CacheDir)
+		saveUserToDisk(u, c.accountDir())
+	}
+
+	if c.ConfigureClient != nil {
+		err = c.ConfigureClient(client)
+		if err != nil {
+			return *client, fmt.Errorf("simplecert: ConfigureClient failed: %w", err)
+		}
 	}
 
 	return *client, nil
 }
+
+// acmeClient is the subset of the lego certificate API used by simplecert
+type acmeClient interface {
+	Obtain(request certificate.ObtainRequest) (*certificate.Resource, error)
+	ObtainForCSR(request certificate.ObtainForCSRRequest) (*certificate.Resource, error)
+	RenewWithOptions(certRes certificate.Resource, options *certificate.RenewOptions) (*certificate.Resource, error)
+	RevokeWithReason(cert []byte, reason *uint) error
+	GetRenewalInfo(req certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error)
+}
+
+// newACMEClient creates an ACME client for the stored user
+// tests can replace it with a fake to run without a CA
+var newACMEClient = func() (acmeClient, error) {
+	u, err := getUser()
+	if err != nil {
+		reportError("client", err)
+		return nil, fmt.Errorf("simplecert: failed to get ACME user: %w", err)
+	}
+
+	client, err := createClient(u, c.DNSServers)
+	if err != nil {
+		reportError("client", err)
+		return nil, fmt.Errorf("simplecert: failed to create lego.Client: %w", err)
+	}
+
+	return client.Certificate, nil
+}
+
+// challengeServer is a standalone HTTP or TLS-ALPN challenge server
+type challengeServer interface {
+	challenge.Provider
+	GetAddress() string
+}
+
+// newHTTPChallengeServer returns a standalone HTTP challenge server binding addr on the network (tcp, tcp4 or tcp6).
+// the CA always connects to port 80, if addr uses another port
+// the traffic must be forwarded to it (e.g. by a load balancer or reverse proxy)
+func newHTTPChallengeServer(network, addr string) (challengeServer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: invalid HTTP address: %w", err)
+	}
+	if network == "" || network == "tcp" {
+		return http01.NewProviderServer(host, port), nil
+	}
+	return &httpNetworkServer{network: network, addr: addr, provider: &memoryProvider{tokens: make(map[string]string)}}, nil
+}
+
+// newTLSChallengeServer returns a standalone TLS-ALPN challenge server binding addr on the network (tcp, tcp4 or tcp6).
+// the CA always connects to port 443, if addr uses another port
+// the traffic must be forwarded to it (e.g. by a load balancer or reverse proxy)
+func newTLSChallengeServer(network, addr string) (challengeServer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: invalid TLS address: %w", err)
+	}
+	if network == "" || network == "tcp" {
+		return tlsalpn01.NewProviderServer(host, port), nil
+	}
+	return &tlsNetworkServer{network: network, addr: addr}, nil
+}
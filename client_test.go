@@ -0,0 +1,129 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+func TestChallengeServersBindConfiguredPort(t *testing.T) {
+	tests := []struct {
+		name      string
+		network   string
+		newServer func(network, addr string) (challengeServer, error)
+	}{
+		{"http", "", newHTTPChallengeServer},
+		{"tls", "", newTLSChallengeServer},
+		{"http tcp4", "tcp4", newHTTPChallengeServer},
+		{"tls tcp4", "tcp4", newTLSChallengeServer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+
+			server, err := tt.newServer(tt.network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if server.GetAddress() != addr {
+				t.Fatalf("server address is %s, want %s", server.GetAddress(), addr)
+			}
+
+			err = server.Present("example.com", "token", "keyAuth")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.CleanUp("example.com", "token", "keyAuth")
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("challenge server does not listen on %s: %s", addr, err)
+			}
+			conn.Close()
+		})
+	}
+}
+
+func TestChallengeServerInvalidAddress(t *testing.T) {
+	for _, addr := range []string{"8080", "example.com", "[::1"} {
+		if _, err := newHTTPChallengeServer("", addr); err == nil {
+			t.Errorf("expected an error for HTTP address %q", addr)
+		}
+		if _, err := newTLSChallengeServer("", addr); err == nil {
+			t.Errorf("expected an error for TLS address %q", addr)
+		}
+	}
+}
+
+// freeAddr returns a loopback address with a port that is currently not in use
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+func TestInitRegistrationProblem(t *testing.T) {
+	// a CA that rejects every new account
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/directory":
+			json.NewEncoder(w).Encode(acme.Directory{
+				NewNonceURL:   srv.URL + "/nonce",
+				NewAccountURL: srv.URL + "/account",
+				NewOrderURL:   srv.URL + "/order",
+				RevokeCertURL: srv.URL + "/revoke",
+				KeyChangeURL:  srv.URL + "/key-change",
+			})
+		case "/nonce":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(acme.ProblemDetails{
+				Type:       "urn:ietf:params:acme:error:unauthorized",
+				Detail:     "account registration is disabled",
+				HTTPStatus: http.StatusForbidden,
+			})
+		}
+	}))
+	defer srv.Close()
+
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CacheDir = t.TempDir()
+	cfg.DirectoryURL = srv.URL + "/directory"
+	cfg.LogFile = ""
+	cfg.HTTPAddress = ""
+	cfg.TLSAddress = ""
+	cfg.HTTPChallengeHandler = true
+
+	_, err := Init(&cfg, nil)
+
+	var problem *acme.ProblemDetails
+	if !errors.As(err, &problem) {
+		t.Fatalf("expected the problem of the CA, got %v", err)
+	}
+	if problem.Type != "urn:ietf:params:acme:error:unauthorized" {
+		t.Fatalf("unexpected problem type %s", problem.Type)
+	}
+}
@@ -6,10 +6,24 @@
 package simplecert
 
 import (
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/lego"
 )
 
 type KeyType string
@@ -34,6 +48,19 @@ var (
 	errNoCheckInterval    = errors.New("simplecert: no check interval set in config")
 	errNoCacheDirPerm     = errors.New("simplecert: no cache directory permission specified in config")
 	errUnsupportedKeyType = errors.New("simplecert: unsupported key type specified in config")
+	errNoKeyFile          = errors.New("simplecert: no KeyFile specified in config, it is required when obtaining a cert for a CSR")
+	errUnsupportedOutput  = errors.New("simplecert: unsupported artifact in OutputFiles, use fullchain, privkey, chain or cert")
+	errManualDNSCleanup   = errors.New("simplecert: ManualDNSCleanup requires ManualDNSPresent")
+	errIPRequiresTLSALPN  = errors.New("simplecert: IP addresses in Domains can only be validated with the TLS challenge, unset DNSProvider, DNSChallengeProvider, ManualDNSPresent, HTTPAddress, WebRoot and HTTPChallengeHandler")
+	errWebRootAndHTTP     = errors.New("simplecert: WebRoot and HTTPAddress are mutually exclusive, set HTTPAddress to an empty string when using WebRoot")
+	errUnsupportedNetwork = errors.New("simplecert: unsupported ChallengeNetwork specified in config, use tcp, tcp4 or tcp6")
+	errNoCertGroups       = errors.New("simplecert: no CertGroups specified in config and the Domains fit into a single cert")
+	errCertGroupsInit     = errors.New("simplecert: CertGroups are specified in config, use InitGroups")
+	errInvalidFileName    = errors.New("simplecert: CertFileName, KeyFileName and CertResourceFileName must be file names without a directory")
+	errLocalResources     = errors.New("simplecert: InitFromResources can not be used in local mode")
+	errCNAMEWithoutDNS    = errors.New("simplecert: DNSCNAMEDelegation requires a DNS challenge, set DNSProvider, DNSChallengeProvider or ManualDNSPresent")
+	errCNAMEDisabled      = errors.New("simplecert: DNSCNAMEDelegation can not be used, following CNAMEs is disabled via LEGO_DISABLE_CNAME_SUPPORT")
+	errHandlerAndHTTP     = errors.New("simplecert: HTTPChallengeHandler can not be combined with WebRoot or HTTPAddress, set HTTPAddress to an empty string when using HTTPChallengeHandler")
 
 	supportedKeyTypes = map[string]bool{
 		EC256:   true,
@@ -44,24 +71,90 @@ var (
 	}
 )
 
+var (
+	// configMu serializes all functions that use the global config c,
+	// like Init, the renewals and Revoke
+	configMu sync.Mutex
+
+	// current holds the active config for functions that must not wait for configMu,
+	// like Status, which can be called from within a renewal hook
+	current atomic.Pointer[Config]
+
+	// hooksMu protects configLocked and pendingHooks
+	hooksMu sync.Mutex
+
+	// configLocked is set while configMu is held via lockConfig
+	configLocked bool
+
+	// pendingHooks are the user callbacks deferred until configMu is released, see afterConfigUnlock
+	pendingHooks []func()
+)
+
+// setConfig makes cfg the global config, configMu must be held
+func setConfig(cfg *Config) {
+	c = cfg
+	current.Store(cfg)
+}
+
+// lockConfig locks configMu
+func lockConfig() {
+	configMu.Lock()
+
+	hooksMu.Lock()
+	configLocked = true
+	hooksMu.Unlock()
+}
+
+// unlockConfig unlocks configMu and calls the user callbacks deferred while it was held
+func unlockConfig() {
+	hooksMu.Lock()
+	hooks := pendingHooks
+	pendingHooks = nil
+	configLocked = false
+	hooksMu.Unlock()
+
+	configMu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// afterConfigUnlock calls the user callback fn once configMu has been released,
+// or right away if configMu is not held. The cacheDir lock is always released before configMu,
+// so fn can call Init, Revoke or RollbackCert, and a slow fn does not hold up the renewals of other cert groups.
+func afterConfigUnlock(fn func()) {
+	hooksMu.Lock()
+	if configLocked {
+		pendingHooks = append(pendingHooks, fn)
+		hooksMu.Unlock()
+		return
+	}
+	hooksMu.Unlock()
+
+	fn()
+}
+
 // Default contains a default configuration
 var Default = &Config{
 	// 30 Days before expiration
 	RenewBefore: 30 * 24,
 	// every two days
-	CheckInterval: 2 * 24 * time.Hour,
-	SSLEmail:      "",
-	DirectoryURL:  "https://acme-v02.api.letsencrypt.org/directory",
-	HTTPAddress:   ":80",
-	TLSAddress:    ":443",
-	CacheDirPerm:  0700,
-	Domains:       []string{},
-	CacheDir:      "letsencrypt",
-	DNSProvider:   "",
-	Local:         false,
-	UpdateHosts:   true,
-	DNSServers:    []string{},
-	KeyType:       RSA2048,
+	CheckInterval:  2 * 24 * time.Hour,
+	SSLEmail:       "",
+	DirectoryURL:   "https://acme-v02.api.letsencrypt.org/directory",
+	HTTPAddress:    ":80",
+	TLSAddress:     ":443",
+	CacheDirPerm:   0700,
+	Domains:        []string{},
+	CacheDir:       "letsencrypt",
+	LogFile:        logFileName,
+	DNSProvider:    "",
+	Local:          false,
+	UpdateHosts:    true,
+	InstallLocalCA: true,
+	DNSServers:     []string{},
+	KeyType:        RSA2048,
 }
 
 // Config allows configuration of simplecert
@@ -70,52 +163,380 @@ type Config struct {
 	// LetsEncrypt Certs are valid for 90 Days
 	RenewBefore int
 
-	// Interval for checking if cert is closer to expiration than RenewBefore
+	// RenewBeforeDuration takes precedence over RenewBefore if set, e.g. 30 * 24 * time.Hour
+	RenewBeforeDuration time.Duration
+
+	// Interval for checking if cert is closer to expiration than RenewBefore,
+	// used if the renewal time can not be computed from the expiry of the cert, or to poll the ARI window
 	CheckInterval time.Duration
 
+	// RenewJitter delays the renewal by a random offset in [0, RenewJitter),
+	// to spread the renewals of many instances with the same configuration.
+	// The offset is derived from the hostname and domains, so it is stable across restarts.
+	RenewJitter time.Duration
+
+	// MaxClockSkew tolerates a local clock running behind the CA: certs are renewed MaxClockSkew before RenewBefore,
+	// and a cert expiring within MaxClockSkew is always renewed, even if ARI suggests a later window.
+	// The cached cert is checked on startup, so a borderline cert is renewed before it is served.
+	MaxClockSkew time.Duration
+
+	// ShouldRenew replaces the RenewBefore comparison to decide whether the cert is renewed, e.g. to align renewals with a maintenance window.
+	// It is called with the leaf on startup and then every CheckInterval. ARI, if enabled, still takes precedence,
+	// and a cert expiring within MaxClockSkew is renewed regardless.
+	ShouldRenew func(cert *x509.Certificate) bool
+
+	// UseARI schedules renewals in the window suggested by the CA via ARI (ACME Renewal Information),
+	// RenewBefore is used if the CA does not support ARI
+	UseARI bool
+
 	// SSLEmail for contact
 	SSLEmail string
 
 	// ACME Directory URL. Can be set to https://acme-staging-v02.api.letsencrypt.org/directory for testing
 	DirectoryURL string
 
-	// Endpoints for webroot challenge
+	// Endpoints for the standalone HTTP and TLS challenge servers, in host:port notation
 	// CAUTION: challenge must be received on port 80 and 443
-	// if you choose different ports here you must redirect the traffic
+	// if you choose different ports here you must redirect the traffic,
+	// e.g. a load balancer forwarding the public port 80 and 443 to 8080 and 8443
 	HTTPAddress string
 
 	TLSAddress string
 
+	// ChallengeNetwork is the network of the standalone HTTP and TLS challenge servers: tcp (default), tcp4 or tcp6.
+	// Use tcp6 on IPv6-only hosts, where the CA reaches the server only via AAAA records.
+	ChallengeNetwork string
+
+	// WebRoot is the document root of an existing webserver serving port 80 (e.g. nginx or apache).
+	// If set, the HTTP challenge files are written to <WebRoot>/.well-known/acme-challenge/
+	// instead of starting a standalone server on HTTPAddress, therefore both are mutually exclusive.
+	WebRoot string
+
+	// HTTPChallengeHandler serves the HTTP challenge via the handler returned by ChallengeHandler,
+	// which can be mounted on an existing server listening on port 80, instead of starting a standalone server on HTTPAddress.
+	// HTTPChallengeHandler, WebRoot and HTTPAddress are mutually exclusive.
+	HTTPChallengeHandler bool
+
 	// UNIX Permission for the CacheDir and all files inside
 	CacheDirPerm os.FileMode
 
+	// KeyEncryptionPassphrase encrypts the private keys in the CacheDir (key.pem and CertResource.json,
+	// as well as the key of the local root CA in local mode) with AES-256-GCM,
+	// using a key derived from the passphrase with scrypt. The key is decrypted when it is loaded.
+	// Plaintext keys are still loaded and encrypted when the cert is written the next time.
+	// Output files, the combined PEM and PKCS#12 archives are consumed by other programs and are not encrypted.
+	KeyEncryptionPassphrase []byte
+
 	// Domains for which to obtain the certificate
+	// Internationalized domains like münchen.example are requested in their punycode form and logged as configured.
+	// IP addresses are supported as well, if the CA issues IP certificates (RFC 8738).
+	// They will be added to the certificate as IP SANs and require the TLS challenge.
 	Domains []string
 
+	// CertFileName, KeyFileName and CertResourceFileName override the names of the files in the CacheDir,
+	// e.g. tls.crt and tls.key. They default to cert.pem, key.pem and CertResource.json.
+	CertFileName         string
+	KeyFileName          string
+	CertResourceFileName string
+
+	// KubernetesSecretLayout names the cert and key tls.crt and tls.key, the keys of a kubernetes.io/tls Secret.
+	// CertFileName and KeyFileName take precedence.
+	KubernetesSecretLayout bool
+
+	// CertGroups obtains a separate cert for each group of domains, instead of a single cert for Domains.
+	// Each cert is stored in a subfolder of the CacheDir and renewed independently, see InitGroups.
+	CertGroups [][]string
+
+	// MaxDomainsPerCert is the number of domains the CA allows in a single cert, defaults to 100 (the limit of Let's Encrypt).
+	// More domains are rejected with ErrTooManyDomains, unless InitGroups is used to split them into several certs.
+	MaxDomainsPerCert int
+
+	// PreflightDNSCheck makes sure all domains resolve to this machine before obtaining a cert with the HTTP or TLS challenge.
+	// See CheckDomainsResolve.
+	PreflightDNSCheck bool
+
+	// PublicIPs the domains are expected to resolve to in the preflight check (optional),
+	// e.g. if this machine is behind NAT or a load balancer. Defaults to the addresses of the interfaces.
+	PublicIPs []net.IP
+
 	// DNSServers overrides the dns resolvers to use for a dns challenge, this is handy if you have a split dns.
 	DNSServers []string
 
+	// DNSProviderConfig contains the credentials for the DNS provider,
+	// instead of reading them from environment variables. The keys are named after the environment variables
+	// of the provider, e.g. CLOUDFLARE_DNS_API_TOKEN. Supported for cloudflare, digitalocean, gandiv5, hetzner and route53,
+	// see the README for the key names. If empty, the credentials are read from the environment.
+	DNSProviderConfig map[string]string
+
+	// DNSPropagationTimeout is the maximum time to wait for the TXT record of a dns challenge to propagate.
+	// DNSPollingInterval is the interval for checking the propagation.
+	// If zero, the defaults of the DNS provider are used.
+	DNSPropagationTimeout time.Duration
+	DNSPollingInterval    time.Duration
+
+	// DisableDNSPropagationCheck skips checking that the TXT record of a dns challenge is visible
+	// before asking the CA to validate it. This is useful with split-horizon DNS, where the record is
+	// visible to the CA but not to the resolvers of this machine.
+	// Warning: if the record has not propagated yet when the CA queries it, the challenge fails.
+	// The DNSPollingInterval is still waited once, increase it to give the record time to propagate.
+	DisableDNSPropagationCheck bool
+
+	// DNSChallengeRetries keeps waiting for the TXT record of a dns challenge for up to this many additional
+	// propagation timeouts, instead of failing the challenge after the first one.
+	// Ignored if DisableDNSPropagationCheck is set.
+	DNSChallengeRetries int
+
+	// DNSCNAMEDelegation is set if _acme-challenge.<domain> is delegated via CNAME to a zone managed by the DNS provider,
+	// e.g. because simplecert must not have write access to the primary zone (acme-dns style).
+	// The CNAME is followed and the TXT record is created at its target. This is the default in lego,
+	// with the option enabled simplecert fails the challenge early, if a domain is not delegated,
+	// instead of writing the record to the primary zone. Requires a DNS challenge and LEGO_DISABLE_CNAME_SUPPORT must not be set.
+	DNSCNAMEDelegation bool
+
 	// Path of the CacheDir
 	CacheDir string
 
+	// LogFile the log is written to in addition to stdout, relative paths are placed inside the CacheDir.
+	// If empty, no logfile is created.
+	LogFile string
+
+	// LogWriter receives the log in addition to stdout instead of the LogFile, e.g. a lumberjack.Logger for custom rotation.
+	// simplecert does not close the writer.
+	LogWriter io.Writer
+
+	// MaxLogSize of the LogFile in bytes, before it is rotated to <LogFile>.1.
+	// Two rotated files are kept. Defaults to 10MB if zero, a negative value disables the rotation.
+	MaxLogSize int64
+
+	// AccountStorageDir is the folder the ACME account (key and registration) is stored in, defaults to the CacheDir.
+	// Use it to keep the account in a persistent location, if the CacheDir is ephemeral.
+	AccountStorageDir string
+
 	// DNSProvider name for DNS challenges (optional)
 	// see: https://godoc.org/github.com/go-acme/lego/providers/dns
 	DNSProvider string
 
+	// DNSChallengeProvider is a custom lego DNS provider for DNS challenges (optional).
+	// If set, it is used instead of DNSProvider, DNSProviderConfig and the environment variables.
+	DNSChallengeProvider challenge.Provider
+
+	// ManualDNSPresent and ManualDNSCleanup create and remove the TXT record for DNS challenges with your own DNS automation (optional).
+	// The record name and value can be computed with dns01.GetChallengeInfo(domain, keyAuth).
+	// They are used instead of DNSProvider, DNSChallengeProvider takes precedence. ManualDNSCleanup may be nil.
+	ManualDNSPresent func(domain, token, keyAuth string) error
+	ManualDNSCleanup func(domain, token, keyAuth string) error
+
+	// KeyFile is the path of an externally managed private key in PEM format.
+	// It is only used together with ObtainWithCSR, and must match the public key of the supplied CSR.
+	KeyFile string
+
+	// OutputFiles maps artifacts to additional target paths, they are written whenever a cert has been obtained or renewed.
+	// Supported artifacts are "fullchain", "privkey", "chain" and "cert" (see the Output* constants),
+	// e.g. {"fullchain": "/etc/nginx/ssl/fullchain.pem", "privkey": "/etc/nginx/ssl/privkey.pem"}.
+	// The cert.pem and key.pem files in the CacheDir are written regardless.
+	OutputFiles map[string]string
+
+	// WriteCombinedPEM additionally writes fullchain+key.pem into the CacheDir on every obtain and renewal,
+	// containing the full chain followed by the private key, as expected by e.g. HAProxy.
+	WriteCombinedPEM bool
+
+	// WritePKCS12 additionally writes cert.p12 into the CacheDir on every obtain and renewal,
+	// a PKCS#12 archive containing the certificate, its chain and the private key.
+	WritePKCS12 bool
+
+	// PKCS12Path is an optional path, the certificate, its chain and the private key
+	// are written to as PKCS#12 archive, whenever a cert has been obtained or renewed.
+	PKCS12Path string
+
+	// PKCS12Password protects the PKCS#12 archives written by WritePKCS12 and to PKCS12Path
+	PKCS12Password string
+
 	// Local runmode
 	Local bool
 
 	// UpdateHosts adds the domains to /etc/hosts if running in local mode
 	UpdateHosts bool
 
+	// InstallLocalCA adds the local root CA to the system trust store with mkcert, when it is created in local mode
+	InstallLocalCA bool
+
+	// LocalIPs are added as IP SANs to the certificate generated in local mode, e.g. 127.0.0.1 and ::1
+	LocalIPs []net.IP
+
+	// LocalValidity of the certificate generated in local mode, defaults to one year
+	LocalValidity time.Duration
+
+	// HTTPClient is used for requests to the ACME server (optional),
+	// e.g. to trust the root certificate of a private or test CA.
+	HTTPClient *http.Client
+
+	// ConfigureClient is called with the lego client after simplecert has set it up and registered the account,
+	// before a cert is obtained, renewed or revoked. Use it for settings simplecert does not expose,
+	// e.g. to replace a challenge provider or remove a challenge type. Returning an error aborts the operation.
+	ConfigureClient func(*lego.Client) error
+
+	// AccountURI of an existing ACME account to use instead of registering a new one (optional),
+	// e.g. an account shared by several services. The account key must be available in the account dir,
+	// for example restored with ImportAccount.
+	AccountURI string
+
+	// AgreeToS controls whether the terms of service of the CA are agreed to when registering the account,
+	// defaults to true if nil. Registration fails if the CA requires the agreement.
+	// Use TermsOfServiceURL to present them to a human before agreeing.
+	AgreeToS *bool
+
+	// SlogHandler receives the key events (obtaining, obtained, challenge timings, renewal scheduled, renewed, renewal failed, reloaded)
+	// as structured records with attributes like domains, not_after and err.
+	// If nil, the events are written with the log package.
+	SlogHandler slog.Handler
+
+	// UserAgent is sent with every request to the ACME server, followed by the default user agent of lego.
+	// Requests made by simplecert itself, e.g. to fetch the ACME directory, only send the UserAgent.
+	UserAgent string
+
 	// KeyType represents the key algorithm as well as the key size or curve to use.
 	KeyType string
 
-	// Handler funcs for graceful service shutdown and restoring
+	// EnableOCSPStapling makes the CertReloader fetch an OCSP response for the loaded cert and staple it to the handshake.
+	// The response is refreshed at half of its validity, failures are logged and the cert is served without a staple.
+	EnableOCSPStapling bool
+
+	// MustStaple adds the OCSP Must-Staple (TLS Feature) extension to the CSR.
+	// Clients will reject the certificate if the server does not staple a valid OCSP response!
+	MustStaple bool
+
+	// PreferredChain is the common name of the root certificate of the chain to use (e.g. "ISRG Root X1"),
+	// if the CA offers alternate chains. The default chain is used if no chain matches.
+	PreferredChain string
+
+	// Profile is the name of the certificate profile to request from the CA (e.g. "shortlived"),
+	// if the CA supports the ACME profiles extension. It is sent with every order, including renewals.
+	// The profiles advertised by the CA are logged on obtain. If empty, the default profile of the CA is used.
+	Profile string
+
+	// ObtainRetries is the number of times obtaining or renewing a certificate is retried
+	// after a transient error (network errors or server errors of the CA).
+	// Permanent errors like rate limits or invalid domains are never retried.
+	// Disabled by default, 3 retries are recommended to ride out short outages of the CA.
+	ObtainRetries int
+
+	// ObtainRetryBackoff is the wait time before the first retry, it is doubled for every subsequent retry.
+	// Defaults to 5 seconds, 10 seconds are recommended together with 3 ObtainRetries.
+	ObtainRetryBackoff time.Duration
+
+	// ObtainTimeout bounds the time Init and ObtainWithCSR spend creating the ACME client, registering the account
+	// and obtaining the cert, including all retries. The requests to the CA fail and the DNS propagation wait ends
+	// once it has passed, and ErrObtainTimeout is returned. Init waits for lego to give up, which may take until
+	// the next poll of the CA. Renewals are not affected. Zero means no timeout.
+	ObtainTimeout time.Duration
+
+	// BeforeChallengeBind is called before the standalone challenge servers bind HTTPAddress and TLSAddress
+	// when obtaining or renewing a cert, e.g. to stop your own listener on these ports.
+	// An error aborts obtaining the cert. AfterChallengeRelease is called once the ports have been released.
+	BeforeChallengeBind   func() error
+	AfterChallengeRelease func()
+
+	// OnError is called for notable failures in addition to returning or logging them,
+	// e.g. to send them to an error tracker. The stage is one of:
+	// "client" (creating the ACME client, registration and challenge setup), "obtain", "save", "renew" and "reload".
+	// Renewal failures are also passed to FailedToRenewCertificate.
+	// It is called once the locks of simplecert have been released, so it may call Init, Revoke or RollbackCert.
+	OnError func(stage string, err error)
+
+	// Handler funcs for graceful service shutdown and restoring.
+	// WillRenewCertificate and DidRenewCertificate are called while the lock of the CacheDir is held,
+	// they must not call Init, InitFromResources, ObtainWithCSR, Revoke or RollbackCert for the same CacheDir.
+	// FailedToRenewCertificate has the same restriction when it is called from Init on startup,
+	// when called by the renewal routine it may call any function.
 	WillRenewCertificate func()
 
 	DidRenewCertificate      func()
 	FailedToRenewCertificate func(error)
+
+	// MaxRenewAttempts is the number of consecutive failed renewals before FailedToRenewCertificate is called.
+	// Failed attempts are retried every RenewRetryInterval while the still valid cert keeps being served,
+	// the handler is called right away if the cert would expire before the next attempt.
+	// Zero or one call the handler on the first failure.
+	MaxRenewAttempts int
+
+	// RenewRetryInterval is the wait time between failed renewal attempts,
+	// defaults to 10 minutes, or the CheckInterval if that is shorter.
+	RenewRetryInterval time.Duration
+
+	// HookTimeout bounds how long the renewal waits for WillRenewCertificate and DidRenewCertificate to return.
+	// On timeout a warning is logged and the renewal proceeds. Zero means waiting indefinitely.
+	HookTimeout time.Duration
+
+	// KeepPreviousCerts is the number of previous certs kept in the archive folder of the CacheDir
+	// when a cert is replaced, see RollbackCert. Disabled if zero.
+	KeepPreviousCerts int
+
+	// DeleteRevokedCert deletes the cached cert and key after revoking it with Revoke.
+	DeleteRevokedCert bool
+
+	// ReloadSignal is the signal that makes the CertReloader load the cert from disk (default: syscall.SIGHUP).
+	// After a renewal, simplecert sends this signal to its own process.
+	ReloadSignal os.Signal
+
+	// WatchCertFiles reloads the cert automatically, when the cert or key file is replaced on disk
+	// by an operator or another process, e.g. cert-manager updating a mounted Kubernetes secret.
+	WatchCertFiles bool
+
+	// WatchInterval is the interval for checking the cert files if WatchCertFiles is set, defaults to 10 seconds
+	WatchInterval time.Duration
+
+	// DisableReloadSignal disables reloading the cert via a signal,
+	// e.g. on windows where SIGHUP is not supported. A renewed cert is then reloaded directly.
+	DisableReloadSignal bool
+
+	// OnChallengeTimings is called after a cert has been obtained or renewed with the time spent
+	// solving the challenges and finalizing the order, e.g. to record them as metrics
+	// or to tune DNSPropagationTimeout. The timings are logged as event as well.
+	OnChallengeTimings func(ChallengeTimings)
+
+	// OnCertChanged is called with the PEM encoded cert and key after a cert has been obtained or renewed and written to disk,
+	// e.g. to push them to a system that can not read the cacheDir. Errors are logged and do not abort the renewal.
+	// The key is empty for certs obtained for a CSR.
+	// It is called once the renewal has released its locks, so it may call Init, Revoke or RollbackCert,
+	// and a slow handler does not hold up the renewals of other cert groups.
+	OnCertChanged func(cert, key []byte) error
+
+	// CertLoggedToCT is called for every signed certificate timestamp (SCT) embedded in a newly obtained or renewed cert,
+	// with the base64 encoded ID of the certificate transparency log and the time the log has seen the cert.
+	// Like OnCertChanged, it is called once the renewal has released its locks.
+	CertLoggedToCT func(logID string, timestamp time.Time)
+
+	// localDir is the folder of the local certs, set by Init when it moves the CacheDir into it
+	localDir string
+}
+
+// environment variables overriding the config
+const (
+	envCacheDir     = "SIMPLECERT_CACHE_DIR"
+	envDirectoryURL = "SIMPLECERT_DIRECTORY_URL"
+	envEmail        = "SIMPLECERT_EMAIL"
+)
+
+// applyEnv sets CacheDir, DirectoryURL and SSLEmail from the environment,
+// if they are empty or still set to the value from the Default config.
+// The precedence is: explicitly configured value, environment variable, default value.
+func applyEnv(cfg *Config) {
+	for _, o := range []struct {
+		env   string
+		field *string
+		def   string
+	}{
+		{envCacheDir, &cfg.CacheDir, Default.CacheDir},
+		{envDirectoryURL, &cfg.DirectoryURL, Default.DirectoryURL},
+		{envEmail, &cfg.SSLEmail, Default.SSLEmail},
+	} {
+		v := os.Getenv(o.env)
+		if v == "" || (*o.field != "" && *o.field != o.def) {
+			continue
+		}
+		log.Println("[INFO] simplecert: using", o.env, "=", v)
+		*o.field = v
+	}
 }
 
 // CheckConfig checks if config can be used to obtain a cert
@@ -126,6 +547,15 @@ func CheckConfig(c *Config) error {
 	if len(c.Domains) == 0 {
 		return errNoDomains
 	}
+	for _, d := range c.Domains {
+		if _, err := asciiDomain(d); err != nil {
+			return fmt.Errorf("simplecert: invalid domain %q in config: %w", d, err)
+		}
+	}
+	// local certs are not limited
+	if n := len(domainSet(c.Domains)); !c.Local && n > c.maxDomainsPerCert() {
+		return &ErrTooManyDomains{Domains: n, Limit: c.maxDomainsPerCert()}
+	}
 	if !c.Local {
 		if c.SSLEmail == "" {
 			return errNoMail
@@ -135,12 +565,45 @@ func CheckConfig(c *Config) error {
 		return errNoDirectoryURL
 	}
 
-	if c.DNSProvider == "" && c.HTTPAddress == "" && c.TLSAddress == "" {
+	if !c.usesDNSChallenge() && c.HTTPAddress == "" && c.TLSAddress == "" && c.WebRoot == "" && !c.HTTPChallengeHandler {
 		return errNoChallenge
 	}
 
-	if c.RenewBefore == 0 {
-		return errNoCacheDir
+	if c.WebRoot != "" && c.HTTPAddress != "" {
+		return errWebRootAndHTTP
+	}
+
+	if c.HTTPChallengeHandler && (c.WebRoot != "" || c.HTTPAddress != "") {
+		return errHandlerAndHTTP
+	}
+
+	for _, name := range []string{c.CertFileName, c.KeyFileName, c.CertResourceFileName} {
+		if name != "" && filepath.Base(name) != name {
+			return errInvalidFileName
+		}
+	}
+	if c.ManualDNSCleanup != nil && c.ManualDNSPresent == nil {
+		return errManualDNSCleanup
+	}
+
+	if c.DNSCNAMEDelegation {
+		if !c.usesDNSChallenge() {
+			return errCNAMEWithoutDNS
+		}
+		if disabled, _ := strconv.ParseBool(os.Getenv("LEGO_DISABLE_CNAME_SUPPORT")); disabled {
+			return errCNAMEDisabled
+		}
+	}
+
+	if !c.Local && hasIPAddress(c.Domains) {
+		if c.TLSAddress == "" || c.usesDNSChallenge() || c.HTTPAddress != "" || c.WebRoot != "" || c.HTTPChallengeHandler {
+			return errIPRequiresTLSALPN
+		}
+		log.Println("[WARNING] requesting a certificate for an IP address, make sure your CA at", c.DirectoryURL, "supports IP identifiers (RFC 8738)")
+	}
+
+	if c.renewBefore() == 0 {
+		return errNoRenewBefore
 	}
 
 	if c.CheckInterval == 0 {
@@ -151,19 +614,116 @@ func CheckConfig(c *Config) error {
 		return errNoCacheDirPerm
 	}
 
+	switch c.ChallengeNetwork {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return errUnsupportedNetwork
+	}
+
 	if !supportedKeyTypes[c.KeyType] {
 		return errUnsupportedKeyType
 	}
 
+	for artifact := range c.OutputFiles {
+		if !supportedOutputFiles[artifact] {
+			return errUnsupportedOutput
+		}
+	}
+
 	if c.WillRenewCertificate == nil && (c.HTTPAddress != "" || c.TLSAddress != "") {
 		log.Println("[WARNING] no WillRenewCertificate handler specified, to handle graceful server shutdown!")
 	}
 	if c.DidRenewCertificate == nil && (c.HTTPAddress != "" || c.TLSAddress != "") {
 		log.Println("[WARNING] no DidRenewCertificate handler specified, to bring the service back up after renewing the certificate!")
 	}
+	if c.MustStaple && !c.EnableOCSPStapling {
+		log.Println("[WARNING] MustStaple is enabled, make sure your server staples OCSP responses (e.g. via EnableOCSPStapling) or clients will reject the certificate!")
+	}
 	if c.FailedToRenewCertificate == nil {
 		log.Println("[WARNING] no FailedToRenewCertificate handler specified! Simplecert will fatal on errors!")
 	}
+	if len(c.KeyEncryptionPassphrase) == 0 && runtime.GOOS != "windows" {
+		// local certs and the local root CA are stored in a subfolder
+		keyDirs := []string{c.CacheDir}
+		if c.Local {
+			keyDirs = append(keyDirs, localCacheDir(c))
+		}
+		for _, dir := range keyDirs {
+			if worldAccessible(dir, c.CacheDirPerm) {
+				log.Println("[WARNING] the cacheDir", dir, "is accessible by other users and the private key is stored unencrypted, restrict its permissions or set a KeyEncryptionPassphrase!")
+				break
+			}
+		}
+	}
 
 	return nil
 }
+
+// hasIPAddress reports whether any of the domains is an IP address
+func hasIPAddress(domains []string) bool {
+	for _, d := range domains {
+		if net.ParseIP(d) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// usesDNSChallenge reports whether a DNS provider has been configured
+func (c *Config) usesDNSChallenge() bool {
+	return c.DNSProvider != "" || c.DNSChallengeProvider != nil || c.ManualDNSPresent != nil
+}
+
+// renewBefore returns the duration before expiry at which the cert is renewed
+func (c *Config) renewBefore() time.Duration {
+	if c.RenewBeforeDuration > 0 {
+		return c.RenewBeforeDuration
+	}
+	return time.Duration(c.RenewBefore) * time.Hour
+}
+
+// maxDomainsPerCert returns the configured MaxDomainsPerCert or the default
+func (c *Config) maxDomainsPerCert() int {
+	if c.MaxDomainsPerCert > 0 {
+		return c.MaxDomainsPerCert
+	}
+	return defaultMaxDomainsPerCert
+}
+
+// certFile returns the name of the cert file in the cacheDir
+func (c *Config) certFile() string {
+	if c.CertFileName != "" {
+		return c.CertFileName
+	}
+	if c.KubernetesSecretLayout {
+		return k8sCertFileName
+	}
+	return certFileName
+}
+
+// keyFile returns the name of the key file in the cacheDir
+func (c *Config) keyFile() string {
+	if c.KeyFileName != "" {
+		return c.KeyFileName
+	}
+	if c.KubernetesSecretLayout {
+		return k8sKeyFileName
+	}
+	return keyFileName
+}
+
+// certResourceFile returns the name of the certificate resource file in the cacheDir
+func (c *Config) certResourceFile() string {
+	if c.CertResourceFileName != "" {
+		return c.CertResourceFileName
+	}
+	return certResourceFileName
+}
+
+// accountDir returns the folder the ACME account is stored in
+func (c *Config) accountDir() string {
+	if c.AccountStorageDir != "" {
+		return c.AccountStorageDir
+	}
+	return c.CacheDir
+}
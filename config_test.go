@@ -0,0 +1,144 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckConfigNoRenewBefore(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.RenewBefore = 0
+
+	err := CheckConfig(&cfg)
+	if !errors.Is(err, errNoRenewBefore) {
+		t.Fatalf("expected %v, got %v", errNoRenewBefore, err)
+	}
+}
+
+func TestCheckConfigRenewBeforeDuration(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.RenewBefore = 0
+	cfg.RenewBeforeDuration = 30 * 24 * time.Hour
+
+	err := CheckConfig(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.renewBefore() != cfg.RenewBeforeDuration {
+		t.Fatalf("expected renewBefore %s, got %s", cfg.RenewBeforeDuration, cfg.renewBefore())
+	}
+}
+
+func TestCheckConfigFileNames(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CertFileName = "tls.crt"
+	cfg.KeyFileName = "tls.key"
+
+	if err := CheckConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.certFile() != "tls.crt" || cfg.keyFile() != "tls.key" || cfg.certResourceFile() != certResourceFileName {
+		t.Fatalf("unexpected file names %s, %s, %s", cfg.certFile(), cfg.keyFile(), cfg.certResourceFile())
+	}
+
+	// explicit names take precedence over the kubernetes layout
+	cfg.KubernetesSecretLayout = true
+	cfg.CertFileName = ""
+	cfg.KeyFileName = "server.key"
+	if cfg.certFile() != "tls.crt" || cfg.keyFile() != "server.key" {
+		t.Fatalf("unexpected file names %s, %s", cfg.certFile(), cfg.keyFile())
+	}
+
+	cfg.KeyFileName = "../tls.key"
+	if err := CheckConfig(&cfg); !errors.Is(err, errInvalidFileName) {
+		t.Fatalf("expected %v, got %v", errInvalidFileName, err)
+	}
+}
+
+func TestCheckConfigIDN(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"münchen.example", "*.münchen.example"}
+
+	if err := CheckConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Domains = []string{"mün chen.example"}
+	if err := CheckConfig(&cfg); err == nil {
+		t.Fatal("expected an error for an invalid domain")
+	}
+}
+
+func TestCheckConfigTooManyDomains(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"a.example.com", "b.example.com", "c.example.com"}
+	cfg.MaxDomainsPerCert = 2
+
+	var tooMany *ErrTooManyDomains
+	if err := CheckConfig(&cfg); !errors.As(err, &tooMany) || tooMany.Domains != 3 || tooMany.Limit != 2 {
+		t.Fatalf("expected ErrTooManyDomains, got %v", err)
+	}
+
+	// local certs are not limited
+	cfg.Local = true
+	if err := CheckConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckConfigCNAMEDelegation(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.DNSCNAMEDelegation = true
+
+	if err := CheckConfig(&cfg); !errors.Is(err, errCNAMEWithoutDNS) {
+		t.Fatalf("expected %v, got %v", errCNAMEWithoutDNS, err)
+	}
+
+	cfg.ManualDNSPresent = func(domain, token, keyAuth string) error { return nil }
+	if err := CheckConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	if err := CheckConfig(&cfg); !errors.Is(err, errCNAMEDisabled) {
+		t.Fatalf("expected %v, got %v", errCNAMEDisabled, err)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv(envCacheDir, "/var/lib/simplecert")
+	t.Setenv(envEmail, "env@example.com")
+
+	cfg := *Default
+	cfg.SSLEmail = "config@example.com"
+	applyEnv(&cfg)
+
+	// the default value is overridden
+	if cfg.CacheDir != "/var/lib/simplecert" {
+		t.Errorf("expected CacheDir from environment, got %q", cfg.CacheDir)
+	}
+	// the explicitly configured value takes precedence
+	if cfg.SSLEmail != "config@example.com" {
+		t.Errorf("expected configured SSLEmail, got %q", cfg.SSLEmail)
+	}
+	// unset variables are ignored
+	if cfg.DirectoryURL != Default.DirectoryURL {
+		t.Errorf("expected default DirectoryURL, got %q", cfg.DirectoryURL)
+	}
+}
@@ -0,0 +1,145 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// ObtainWithCSR obtains a certificate for the supplied CSR, instead of generating a new private key.
+// This allows to keep the private key in a HSM or another external key store.
+// The private key matching the CSR must be available at cfg.KeyFile,
+// it is used by the returned CertReloader together with the obtained certificate.
+// The cacheDir will contain the obtained cert chain and the CSR (for renewals), but never a private key.
+// If cfg.Domains is empty, the domains are taken from the CSR.
+// Cached certs are loaded and renewed just like with Init.
+func ObtainWithCSR(cfg *Config, csr *x509.CertificateRequest) (*CertReloader, error) {
+	if cfg.KeyFile == "" {
+		return nil, errNoKeyFile
+	}
+
+	// use the domains from the CSR if none have been configured
+	if len(cfg.Domains) == 0 {
+		cfg.Domains = certcrypto.ExtractDomainsCSR(csr)
+	}
+
+	// apply environment overrides and validate config
+	applyEnv(cfg)
+	err := CheckConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// update global config
+	lockConfig()
+	defer unlockConfig()
+	setConfig(cfg)
+
+	// make sure the cacheDir exists
+	ensureCacheDirExists(c.CacheDir)
+
+	// open logfile handle
+	logFile, err := openLogFile()
+	if err != nil {
+		return nil, err
+	}
+
+	certFilePath := filepath.Join(c.CacheDir, c.certFile())
+
+	// acquire the cacheDir lock, see Init
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
+	// load the cached cert, unless the domains of the CSR have changed
+	if csrCertCached(c.CacheDir) {
+		if err = checkKeyPair(certFilePath, c.KeyFile); err != nil {
+			log.Println("[ERROR] simplecert: cached cert does not match KeyFile, obtaining a new certificate for the CSR: ", err)
+		} else if !domainsChanged(certFilePath, c.KeyFile) {
+			return loadStoredCert(nil, certFilePath, c.KeyFile, logFile, nil)
+		} else {
+			log.Println("[INFO] domains have changed. Obtaining a new certificate for the CSR...")
+		}
+	}
+
+	// the ObtainTimeout includes the account registration
+	deadline := obtainDeadline()
+
+	// get ACME Client
+	var client acmeClient
+	err = withDeadline(deadline, func() (errClient error) {
+		client, errClient = newACMEClient()
+		return errClient
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// bundle CA with certificate to avoid "transport: x509: certificate signed by unknown authority" error
+	request := certificate.ObtainForCSRRequest{
+		CSR:            csr,
+		Bundle:         true,
+		PreferredChain: c.PreferredChain,
+		Profile:        c.Profile,
+	}
+
+	logEvent(slog.LevelInfo, "obtaining cert for CSR", "domains", c.Domains)
+
+	var cert *certificate.Resource
+	err = withDeadline(deadline, func() error {
+		return withChallengeServers("obtaining cert for CSR", func() (errObtain error) {
+			cert, errObtain = client.ObtainForCSR(request)
+			return errObtain
+		})
+	})
+	if err != nil {
+		reportError("obtain", err)
+		return nil, fmt.Errorf("%w for CSR: %w", ErrObtainFailed, err)
+	}
+
+	logEvent(slog.LevelInfo, "obtained cert for CSR", "domains", c.Domains, "not_after", certNotAfter(cert))
+	checkPreferredChain(cert)
+
+	// Save cert and CSR to disk, the resource does not contain a private key
+	err = saveCertToDisk(cert, c.CacheDir)
+	if err != nil {
+		reportError("save", err)
+		return nil, fmt.Errorf("simplecert: failed to write cert to disk: %w", err)
+	}
+
+	log.Println("[INFO] simplecert: wrote new cert to disk!")
+
+	notifyCTLogs(cert)
+	notifyCertChanged(cert)
+
+	certReloader, err := NewCertReloader(certFilePath, c.KeyFile, logFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// kickoff renewal routine
+	// renewals will reuse the CSR stored in the certificate resource
+	startRenewalRoutine(cert, certReloader)
+
+	return certReloader, nil
+}
+
+// csrCertCached checks if a cert obtained for a CSR exists in cacheDir
+func csrCertCached(cacheDir string) bool {
+	_, errCert := os.Stat(filepath.Join(cacheDir, c.certFile()))
+	_, errResource := os.Stat(filepath.Join(cacheDir, c.certResourceFile()))
+	return errCert == nil && errResource == nil
+}
@@ -0,0 +1,191 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// csrClient is a fakeClient issuing certs for CSRs
+type csrClient struct {
+	*fakeClient
+	obtainForCSR func(certificate.ObtainForCSRRequest) (*certificate.Resource, error)
+}
+
+func (f *csrClient) ObtainForCSR(request certificate.ObtainForCSRRequest) (*certificate.Resource, error) {
+	return f.obtainForCSR(request)
+}
+
+func TestObtainWithCSR(t *testing.T) {
+	csr, keyFile := testCSR(t, "example.com", "www.example.com")
+
+	var domains []string
+	setupCSRTest(t, &csrClient{fakeClient: &fakeClient{t: t}, obtainForCSR: func(request certificate.ObtainForCSRRequest) (*certificate.Resource, error) {
+		domains = certcrypto.ExtractDomainsCSR(request.CSR)
+		return testCertForCSR(t, request.CSR), nil
+	}})
+
+	cfg := csrTestConfig(t, keyFile)
+	reloader, err := ObtainWithCSR(cfg, csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// the domains are taken from the CSR
+	want := []string{"example.com", "www.example.com"}
+	if !slices.Equal(cfg.Domains, want) {
+		t.Errorf("config has domains %v, want %v", cfg.Domains, want)
+	}
+	if !slices.Equal(domains, want) {
+		t.Errorf("obtained cert for %v, want %v", domains, want)
+	}
+
+	// the cacheDir never contains a private key
+	if _, err = os.Stat(filepath.Join(cfg.CacheDir, keyFileName)); !os.IsNotExist(err) {
+		t.Errorf("expected no key in the cacheDir, got %v", err)
+	}
+}
+
+func TestObtainWithCSRNoKeyFile(t *testing.T) {
+	csr, _ := testCSR(t, "example.com")
+
+	if _, err := ObtainWithCSR(csrTestConfig(t, ""), csr); !errors.Is(err, errNoKeyFile) {
+		t.Fatalf("expected %v, got %v", errNoKeyFile, err)
+	}
+}
+
+func TestObtainWithCSRTimeout(t *testing.T) {
+	csr, keyFile := testCSR(t, "example.com")
+
+	// a CA that does not answer, until the requests fail at the deadline
+	setupCSRTest(t, &csrClient{fakeClient: &fakeClient{t: t}, obtainForCSR: func(certificate.ObtainForCSRRequest) (*certificate.Resource, error) {
+		if obtainUntil.IsZero() {
+			return nil, errors.New("no deadline set for the requests")
+		}
+		time.Sleep(time.Until(obtainUntil))
+		return nil, errors.New("context deadline exceeded")
+	}})
+
+	cfg := csrTestConfig(t, keyFile)
+	cfg.ObtainTimeout = 50 * time.Millisecond
+
+	if _, err := ObtainWithCSR(cfg, csr); !errors.Is(err, ErrObtainTimeout) {
+		t.Fatalf("expected %v, got %v", ErrObtainTimeout, err)
+	}
+	if !obtainUntil.IsZero() {
+		t.Fatal("expected the deadline to be reset")
+	}
+}
+
+// setupCSRTest replaces the ACME client with the fake
+func setupCSRTest(t *testing.T, fake acmeClient) {
+	t.Helper()
+
+	newClient := newACMEClient
+	newACMEClient = func() (acmeClient, error) { return fake, nil }
+
+	t.Cleanup(func() {
+		lockConfig()
+		setConfig(nil)
+		newACMEClient = newClient
+		unlockConfig()
+	})
+}
+
+// csrTestConfig returns a config for ObtainWithCSR without domains, using a temporary cacheDir
+func csrTestConfig(t *testing.T, keyFile string) *Config {
+	t.Helper()
+
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.CacheDir = t.TempDir()
+	cfg.LogFile = ""
+	cfg.HTTPAddress = ""
+	cfg.TLSAddress = ""
+	cfg.HTTPChallengeHandler = true
+	cfg.FailedToRenewCertificate = func(error) {}
+	cfg.KeyFile = keyFile
+	return &cfg
+}
+
+// testCSR returns a CSR for the domains and the path of its private key
+func testCSR(t *testing.T, domains ...string) (*x509.CertificateRequest, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return csr, keyFile
+}
+
+// testCertForCSR returns a certificate resource for the public key and domains of the CSR, issued by a throwaway CA
+func testCertForCSR(t *testing.T, csr *x509.CertificateRequest) *certificate.Resource {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &certificate.Resource{
+		Domain:      csr.Subject.CommonName,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		CSR:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}),
+	}
+}
@@ -0,0 +1,119 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// OID of the embedded SCT list extension, see RFC 6962 section 3.3
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// signedCertificateTimestamp embedded in a certificate
+type signedCertificateTimestamp struct {
+	// base64 encoded ID of the CT log
+	logID string
+
+	// time at which the log has seen the certificate
+	timestamp time.Time
+}
+
+// notifyCTLogs invokes the CertLoggedToCT handler for every SCT embedded in the certificate
+// certificates without SCTs are ignored
+func notifyCTLogs(cert *certificate.Resource) {
+	handler := c.CertLoggedToCT
+	if handler == nil {
+		return
+	}
+
+	certificates, err := parsePEMBundle(cert.Certificate)
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to parse cert for SCTs: ", err)
+		return
+	}
+
+	scts, err := parseSCTs(certificates[0])
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to parse SCTs: ", err)
+		return
+	}
+
+	afterConfigUnlock(func() {
+		for _, s := range scts {
+			handler(s.logID, s.timestamp)
+		}
+	})
+}
+
+// parseSCTs extracts the SCTs embedded in the certificate
+func parseSCTs(cert *x509.Certificate) ([]signedCertificateTimestamp, error) {
+	var ext []byte
+	for _, e := range cert.Extensions {
+		if e.Id.Equal(oidSCTList) {
+			ext = e.Value
+			break
+		}
+	}
+	if ext == nil {
+		return nil, nil
+	}
+
+	// the extension value is an OCTET STRING containing the TLS encoded SCT list
+	var list []byte
+	if _, err := asn1.Unmarshal(ext, &list); err != nil {
+		return nil, err
+	}
+
+	list, err := readVector(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var scts []signedCertificateTimestamp
+	for len(list) > 0 {
+		var sct []byte
+		if len(list) < 2 {
+			return nil, errors.New("truncated SCT list")
+		}
+		sct, err = readVector(list)
+		if err != nil {
+			return nil, err
+		}
+		list = list[2+len(sct):]
+
+		// version (1 byte), log id (32 bytes), timestamp (8 bytes), ...
+		if len(sct) < 41 {
+			return nil, errors.New("truncated SCT")
+		}
+		ms := binary.BigEndian.Uint64(sct[33:41])
+		scts = append(scts, signedCertificateTimestamp{
+			logID:     base64.StdEncoding.EncodeToString(sct[1:33]),
+			timestamp: time.UnixMilli(int64(ms)).UTC(),
+		})
+	}
+
+	return scts, nil
+}
+
+// readVector reads a TLS vector with a 2 byte length prefix
+func readVector(b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return nil, errors.New("truncated vector")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, errors.New("truncated vector")
+	}
+	return b[2 : 2+n], nil
+}
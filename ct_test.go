@@ -0,0 +1,73 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+func TestNotifyCTLogsAfterUnlock(t *testing.T) {
+	loggedAt := time.UnixMilli(time.Now().UnixMilli()).UTC()
+
+	// a single SCT: version, log id, timestamp, no extensions and no signature
+	sct := make([]byte, 41)
+	sct[1] = 0xab
+	binary.BigEndian.PutUint64(sct[33:], uint64(loggedAt.UnixMilli()))
+	list := binary.BigEndian.AppendUint16(nil, uint16(2+len(sct)))
+	list = binary.BigEndian.AppendUint16(list, uint16(len(sct)))
+	list = append(list, sct...)
+	ext, err := asn1.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "example.com"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidSCTList, Value: ext}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &certificate.Resource{Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+
+	var logged []time.Time
+	lockConfig()
+	setConfig(&Config{CertLoggedToCT: func(logID string, timestamp time.Time) {
+		logged = append(logged, timestamp)
+	}})
+	defer setConfig(nil)
+
+	// the handler is called once the lock is released
+	notifyCTLogs(cert)
+	if len(logged) != 0 {
+		t.Error("CertLoggedToCT called while holding configMu")
+	}
+	unlockConfig()
+
+	if len(logged) != 1 || !logged[0].Equal(loggedAt) {
+		t.Fatalf("got SCTs logged at %v, want [%s]", logged, loggedAt)
+	}
+}
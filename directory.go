@@ -0,0 +1,76 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// timeout for fetching the ACME directory
+const directoryTimeout = 30 * time.Second
+
+// directoryMeta contains the metadata of the ACME directory used by simplecert
+type directoryMeta struct {
+	TermsOfService string            `json:"termsOfService"`
+	Profiles       map[string]string `json:"profiles"`
+}
+
+// fetchDirectoryMeta fetches the ACME directory of the configured CA and returns its metadata
+func fetchDirectoryMeta(cfg *Config) (*directoryMeta, error) {
+	client := &http.Client{Timeout: directoryTimeout}
+	if cfg.HTTPClient != nil {
+		client = cfg.HTTPClient
+	}
+
+	req, err := http.NewRequest(http.MethodGet, cfg.DirectoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UserAgent != "" {
+		req.Header.Set("User-Agent", cfg.UserAgent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var dir struct {
+		Meta directoryMeta `json:"meta"`
+	}
+	err = sonnet.Unmarshal(b, &dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dir.Meta, nil
+}
+
+// TermsOfServiceURL returns the URL of the terms of service of the CA configured in cfg,
+// to present them to a human before agreeing to them, see AgreeToS.
+// An empty string is returned if the CA has no terms of service.
+func TermsOfServiceURL(cfg *Config) (string, error) {
+	meta, err := fetchDirectoryMeta(cfg)
+	if err != nil {
+		return "", fmt.Errorf("simplecert: failed to fetch ACME directory: %w", err)
+	}
+	return meta.TermsOfService, nil
+}
@@ -0,0 +1,284 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/digitalocean"
+	"github.com/go-acme/lego/v4/providers/dns/gandiv5"
+	"github.com/go-acme/lego/v4/providers/dns/hetzner"
+	"github.com/go-acme/lego/v4/providers/dns/route53"
+)
+
+// supported keys of DNSProviderConfig per DNS provider
+// the keys are named after the environment variables used by the provider
+var dnsProviderConfigKeys = map[string][]string{
+	"cloudflare":   {"CLOUDFLARE_EMAIL", "CLOUDFLARE_API_KEY", "CLOUDFLARE_DNS_API_TOKEN", "CLOUDFLARE_ZONE_API_TOKEN"},
+	"digitalocean": {"DO_AUTH_TOKEN"},
+	"gandiv5":      {"GANDIV5_PERSONAL_ACCESS_TOKEN"},
+	"hetzner":      {"HETZNER_API_KEY"},
+	"route53":      {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_HOSTED_ZONE_ID"},
+}
+
+// newDNSProvider returns c.DNSChallengeProvider if set, a provider calling ManualDNSPresent and ManualDNSCleanup,
+// or creates the DNS provider configured in c.DNSProvider.
+// The credentials are taken from c.DNSProviderConfig if set, otherwise from the environment.
+func newDNSProvider() (challenge.Provider, error) {
+	if c.DNSChallengeProvider != nil {
+		return c.DNSChallengeProvider, nil
+	}
+	if c.ManualDNSPresent != nil {
+		return &manualDNSProvider{present: c.ManualDNSPresent, cleanup: c.ManualDNSCleanup}, nil
+	}
+
+	if len(c.DNSProviderConfig) == 0 {
+		return dns.NewDNSChallengeProviderByName(c.DNSProvider)
+	}
+
+	keys, ok := dnsProviderConfigKeys[c.DNSProvider]
+	if !ok {
+		return nil, fmt.Errorf("DNSProviderConfig is not supported for DNS provider %q, use environment variables instead", c.DNSProvider)
+	}
+
+	// reject unknown keys, a typo would otherwise result in a confusing authentication error
+	for k := range c.DNSProviderConfig {
+		if !slices.Contains(keys, k) {
+			return nil, fmt.Errorf("unsupported DNSProviderConfig key %q for DNS provider %q, supported keys: %s", k, c.DNSProvider, strings.Join(keys, ", "))
+		}
+	}
+
+	v := c.DNSProviderConfig
+
+	switch c.DNSProvider {
+	case "cloudflare":
+		cfg := cloudflare.NewDefaultConfig()
+		cfg.AuthEmail = v["CLOUDFLARE_EMAIL"]
+		cfg.AuthKey = v["CLOUDFLARE_API_KEY"]
+		cfg.AuthToken = v["CLOUDFLARE_DNS_API_TOKEN"]
+		cfg.ZoneToken = v["CLOUDFLARE_ZONE_API_TOKEN"]
+		if cfg.ZoneToken == "" {
+			cfg.ZoneToken = cfg.AuthToken
+		}
+		return cloudflare.NewDNSProviderConfig(cfg)
+	case "digitalocean":
+		cfg := digitalocean.NewDefaultConfig()
+		cfg.AuthToken = v["DO_AUTH_TOKEN"]
+		return digitalocean.NewDNSProviderConfig(cfg)
+	case "gandiv5":
+		cfg := gandiv5.NewDefaultConfig()
+		cfg.PersonalAccessToken = v["GANDIV5_PERSONAL_ACCESS_TOKEN"]
+		return gandiv5.NewDNSProviderConfig(cfg)
+	case "hetzner":
+		cfg := hetzner.NewDefaultConfig()
+		cfg.APIKey = v["HETZNER_API_KEY"]
+		return hetzner.NewDNSProviderConfig(cfg)
+	default: // route53
+		cfg := route53.NewDefaultConfig()
+		cfg.AccessKeyID = v["AWS_ACCESS_KEY_ID"]
+		cfg.SecretAccessKey = v["AWS_SECRET_ACCESS_KEY"]
+		cfg.SessionToken = v["AWS_SESSION_TOKEN"]
+		cfg.Region = v["AWS_REGION"]
+		if id := v["AWS_HOSTED_ZONE_ID"]; id != "" {
+			cfg.HostedZoneID = id
+		}
+		return route53.NewDNSProviderConfig(cfg)
+	}
+}
+
+// timeoutProvider wraps a DNS provider to override its propagation timeout and polling interval
+type timeoutProvider struct {
+	challenge.Provider
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// Timeout returns the configured propagation timeout and polling interval,
+// falling back to the values of the wrapped provider or lego's defaults.
+func (p timeoutProvider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+	if pt, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		timeout, interval = pt.Timeout()
+	}
+
+	if p.timeout > 0 {
+		timeout = p.timeout
+	}
+	if p.interval > 0 {
+		interval = p.interval
+	}
+
+	return timeout, interval
+}
+
+// sequential is implemented by DNS providers that can only solve one challenge at a time,
+// lego solves the challenges of such a provider one after another
+type sequential interface {
+	Sequential() time.Duration
+}
+
+// sequentialTimeoutProvider is a timeoutProvider wrapping a sequential provider
+type sequentialTimeoutProvider struct {
+	timeoutProvider
+}
+
+func (p sequentialTimeoutProvider) Sequential() time.Duration {
+	return p.Provider.(sequential).Sequential()
+}
+
+// skipPropagationCheck reports the TXT record as propagated without querying any nameserver
+func skipPropagationCheck(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+	return true, nil
+}
+
+// retryPropagationCheck returns a pre-check, that polls for the TXT record for up to 1+retries
+// propagation timeouts of p. It blocks inside the propagation wait of lego,
+// which ends as soon as the check reports the record as propagated.
+func retryPropagationCheck(p challenge.Provider, retries int) dns01.WrapPreCheckFunc {
+	timeout, interval := dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+	if pt, ok := p.(challenge.ProviderTimeout); ok {
+		timeout, interval = pt.Timeout()
+	}
+
+	return func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+		var lastErr error
+		for attempt := 0; attempt <= retries; attempt++ {
+			if attempt > 0 {
+				log.Printf("[WARNING] simplecert: TXT record for %s has not propagated within %s, waiting again (%d/%d)", domain, timeout, attempt, retries)
+			}
+
+			deadline := time.Now().Add(timeout)
+			for {
+				ok, err := check(fqdn, value)
+				if ok {
+					return true, nil
+				}
+				if err != nil {
+					lastErr = err
+				}
+				if time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(interval)
+			}
+		}
+
+		if lastErr == nil {
+			lastErr = errors.New("record not found")
+		}
+		return false, fmt.Errorf("simplecert: TXT record for %s has not propagated after %d retries: %w", domain, retries, lastErr)
+	}
+}
+
+// withDNSTimeouts applies the DNSPropagationTimeout and DNSPollingInterval from the config to p.
+// When obtaining a cert, the propagation timeout ends with the ObtainTimeout at the latest.
+func withDNSTimeouts(p challenge.Provider) challenge.Provider {
+	timeout := c.DNSPropagationTimeout
+	if !obtainUntil.IsZero() {
+		if timeout <= 0 {
+			timeout = dns01.DefaultPropagationTimeout
+			if pt, ok := p.(challenge.ProviderTimeout); ok {
+				timeout, _ = pt.Timeout()
+			}
+		}
+		// a zero timeout would disable the override
+		timeout = min(timeout, max(time.Until(obtainUntil), time.Millisecond))
+	}
+
+	if timeout <= 0 && c.DNSPollingInterval <= 0 {
+		return p
+	}
+
+	tp := timeoutProvider{
+		Provider: p,
+		timeout:  timeout,
+		interval: c.DNSPollingInterval,
+	}
+
+	// the wrapper must only be sequential if the provider is
+	if _, ok := p.(sequential); ok {
+		return sequentialTimeoutProvider{tp}
+	}
+	return tp
+}
+
+// challengeInfo returns the name and value of the TXT record for a dns challenge,
+// following CNAMEs of _acme-challenge unless LEGO_DISABLE_CNAME_SUPPORT is set.
+// tests can replace it to run without DNS
+var challengeInfo = dns01.GetChallengeInfo
+
+// cnameDelegationProvider makes sure the challenge record of a domain is delegated via CNAME,
+// before the wrapped provider creates the TXT record in the delegated zone
+type cnameDelegationProvider struct {
+	challenge.Provider
+}
+
+// Present creates the TXT record, if _acme-challenge is delegated via CNAME
+func (p cnameDelegationProvider) Present(domain, token, keyAuth string) error {
+	info := challengeInfo(domain, keyAuth)
+	if info.EffectiveFQDN == info.FQDN {
+		return fmt.Errorf("simplecert: %s is not delegated via CNAME, but DNSCNAMEDelegation is enabled", info.FQDN)
+	}
+
+	log.Println("[INFO] simplecert:", info.FQDN, "is delegated to", info.EffectiveFQDN)
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+// Timeout returns the propagation timeout and polling interval of the wrapped provider or lego's defaults
+func (p cnameDelegationProvider) Timeout() (timeout, interval time.Duration) {
+	if pt, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return pt.Timeout()
+	}
+	return dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+}
+
+// sequentialCNAMEDelegationProvider is a cnameDelegationProvider wrapping a sequential provider
+type sequentialCNAMEDelegationProvider struct {
+	cnameDelegationProvider
+}
+
+func (p sequentialCNAMEDelegationProvider) Sequential() time.Duration {
+	return p.Provider.(sequential).Sequential()
+}
+
+// withCNAMEDelegation checks the CNAME delegation of the challenge records if DNSCNAMEDelegation is enabled
+func withCNAMEDelegation(p challenge.Provider) challenge.Provider {
+	if !c.DNSCNAMEDelegation {
+		return p
+	}
+	if _, ok := p.(sequential); ok {
+		return sequentialCNAMEDelegationProvider{cnameDelegationProvider{Provider: p}}
+	}
+	return cnameDelegationProvider{Provider: p}
+}
+
+// manualDNSProvider solves DNS challenges with the ManualDNSPresent and ManualDNSCleanup callbacks
+type manualDNSProvider struct {
+	present func(domain, token, keyAuth string) error
+	cleanup func(domain, token, keyAuth string) error
+}
+
+// Present creates the TXT record
+func (p *manualDNSProvider) Present(domain, token, keyAuth string) error {
+	return p.present(domain, token, keyAuth)
+}
+
+// CleanUp removes the TXT record
+func (p *manualDNSProvider) CleanUp(domain, token, keyAuth string) error {
+	if p.cleanup == nil {
+		return nil
+	}
+	return p.cleanup(domain, token, keyAuth)
+}
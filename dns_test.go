@@ -0,0 +1,107 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+func TestRetryPropagationCheck(t *testing.T) {
+	p := timeoutProvider{
+		Provider: &manualDNSProvider{},
+		timeout:  20 * time.Millisecond,
+		interval: 5 * time.Millisecond,
+	}
+
+	// the record shows up after the first propagation timeout
+	appearsAt := time.Now().Add(30 * time.Millisecond)
+	check := func(fqdn, value string) (bool, error) {
+		if time.Now().Before(appearsAt) {
+			return false, errors.New("NXDOMAIN")
+		}
+		return true, nil
+	}
+
+	ok, err := retryPropagationCheck(p, 1)("example.com", "_acme-challenge.example.com.", "value", check)
+	if !ok || err != nil {
+		t.Fatalf("expected the record to be found on retry, got %v, %v", ok, err)
+	}
+
+	appearsAt = time.Now().Add(time.Hour)
+	ok, err = retryPropagationCheck(p, 1)("example.com", "_acme-challenge.example.com.", "value", check)
+	if ok || err == nil {
+		t.Fatalf("expected the check to fail after the retries, got %v, %v", ok, err)
+	}
+}
+
+func TestCNAMEDelegation(t *testing.T) {
+	defer func(info func(domain, keyAuth string) dns01.ChallengeInfo) { challengeInfo = info }(challengeInfo)
+
+	delegated := map[string]string{"example.com": "example.com.acme.example.net."}
+	challengeInfo = func(domain, keyAuth string) dns01.ChallengeInfo {
+		fqdn := "_acme-challenge." + domain + "."
+		info := dns01.ChallengeInfo{FQDN: fqdn, EffectiveFQDN: fqdn}
+		if target, ok := delegated[domain]; ok {
+			info.EffectiveFQDN = target
+		}
+		return info
+	}
+
+	var presented []string
+	p := cnameDelegationProvider{Provider: &manualDNSProvider{
+		present: func(domain, token, keyAuth string) error {
+			presented = append(presented, domain)
+			return nil
+		},
+	}}
+
+	if err := p.Present("example.com", "token", "keyAuth"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the record is not written to the primary zone
+	if err := p.Present("example.org", "token", "keyAuth"); err == nil {
+		t.Fatal("expected an error for a domain that is not delegated")
+	}
+	if len(presented) != 1 || presented[0] != "example.com" {
+		t.Fatalf("presented %v, want [example.com]", presented)
+	}
+}
+
+// sequentialDNSProvider is a DNS provider that requires solving the challenges one at a time
+type sequentialDNSProvider struct {
+	manualDNSProvider
+}
+
+func (p *sequentialDNSProvider) Sequential() time.Duration {
+	return time.Minute
+}
+
+func TestWrappersKeepSequential(t *testing.T) {
+	setConfig(&Config{DNSPropagationTimeout: time.Minute, DNSCNAMEDelegation: true})
+	defer setConfig(nil)
+
+	wrappers := map[string]func(challenge.Provider) challenge.Provider{
+		"withDNSTimeouts":     withDNSTimeouts,
+		"timeChallenges":      timeChallenges,
+		"withCNAMEDelegation": withCNAMEDelegation,
+	}
+
+	for name, wrap := range wrappers {
+		p, ok := wrap(&sequentialDNSProvider{}).(sequential)
+		if !ok || p.Sequential() != time.Minute {
+			t.Errorf("%s: expected the wrapper of a sequential provider to be sequential", name)
+		}
+		if _, ok := wrap(&manualDNSProvider{}).(sequential); ok {
+			t.Errorf("%s: expected the wrapper of a parallel provider not to be sequential", name)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import "errors"
+
+// Sentinel errors for the common failure modes, they wrap the underlying cause
+// and can be checked with errors.Is, while errors.As gives access to the cause, e.g. *acme.ProblemDetails.
+var (
+	// ErrObtainFailed is returned if obtaining a new cert from the CA failed
+	ErrObtainFailed = errors.New("simplecert: failed to obtain cert")
+
+	// ErrRenewFailed is returned if renewing the cert failed
+	ErrRenewFailed = errors.New("simplecert: failed to renew cert")
+
+	// ErrLoadFailed is returned if the cached cert could not be loaded
+	ErrLoadFailed = errors.New("simplecert: failed to load cached cert")
+
+	// ErrObtainTimeout is returned if a cert could not be obtained within the ObtainTimeout
+	ErrObtainTimeout = errors.New("simplecert: timed out obtaining cert")
+)
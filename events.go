@@ -0,0 +1,56 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// logEvent emits a key event as structured record to the configured SlogHandler,
+// or as a log line with the attributes appended if there is none.
+// args are key value pairs, as for slog.Logger.Log.
+func logEvent(level slog.Level, msg string, args ...any) {
+	// read the published config, events are also logged by the reloaders
+	if cfg := current.Load(); cfg != nil && cfg.SlogHandler != nil {
+		slog.New(cfg.SlogHandler).Log(context.Background(), level, "simplecert: "+msg, args...)
+		return
+	}
+
+	var attrs strings.Builder
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&attrs, " %v=%v", args[i], args[i+1])
+	}
+
+	tag := level.String()
+	if level == slog.LevelWarn {
+		tag = "WARNING"
+	}
+	log.Printf("[%s] simplecert: %s%s\n", tag, msg, attrs.String())
+}
+
+// reportError passes err to the OnError handler, if one is configured
+func reportError(stage string, err error) {
+	if cfg := current.Load(); cfg != nil && cfg.OnError != nil {
+		handler := cfg.OnError
+		afterConfigUnlock(func() { handler(stage, err) })
+	}
+}
+
+// certNotAfter returns the expiry of the leaf in cert, or the zero time if it can not be parsed
+func certNotAfter(cert *certificate.Resource) time.Time {
+	certificates, err := parsePEMBundle(cert.Certificate)
+	if err != nil {
+		return time.Time{}
+	}
+	return certificates[0].NotAfter
+}
@@ -0,0 +1,76 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenewEmitsEvents(t *testing.T) {
+	setupRenewTest(t, &fakeClient{t: t})
+	c.DidRenewCertificate = func() {}
+
+	var buf bytes.Buffer
+	c.SlogHandler = slog.NewJSONHandler(&buf, nil)
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := renew(cert, newCertReloader()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`"msg":"simplecert: renewing cert","domains":["example.com"]`,
+		`"msg":"simplecert: renewed cert","domains":["example.com"],"not_after":`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing event %s in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestReportErrorAfterUnlock(t *testing.T) {
+	setupRenewTest(t, &fakeClient{t: t, renewErr: errors.New("renewal failed")})
+	cfg := c
+
+	// the handler may call functions locking configMu
+	var stages []string
+	cfg.OnError = func(stage string, err error) {
+		stages = append(stages, stage)
+		if !configMu.TryLock() {
+			t.Error("OnError called while holding configMu")
+			return
+		}
+		configMu.Unlock()
+	}
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// renew like the renewal routine
+	withoutConfigLock(func() {
+		restore := useConfig(cfg)
+		if err := renewLocked(cert, newCertReloader()); err != nil {
+			reportError("renew", err)
+		}
+		if len(stages) != 0 {
+			t.Error("OnError called before the lock is released")
+		}
+		restore()
+	})
+	if len(stages) != 1 || stages[0] != "renew" {
+		t.Fatalf("OnError called for %v, want [renew]", stages)
+	}
+}
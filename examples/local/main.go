@@ -13,7 +13,8 @@ import (
 )
 
 // This example demonstrates how spin up a simple HTTPS webserver for local development, with a locally trusted certificate.
-// The mkcert (https://github.com/FiloSottile/mkcert) util must be installed for this to work, the generated certificates will be valid for 10 years.
+// The certificate is signed by a local root CA, which is added to your trust store if the mkcert (https://github.com/FiloSottile/mkcert) util is installed.
+// The generated certificates will be valid for one year.
 // Caution: simplecert will automatically add an entry to your /etc/hosts to point the specified domain(s) to localhost!
 func main() {
 	// handle incoming HTTP request via the
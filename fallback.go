@@ -0,0 +1,74 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+)
+
+// InitWithFallback returns a CertReloader serving a temporary self signed cert for the configured domains
+// and runs Init in the background, so a TLS listener can be started right away on a fresh host.
+// Once Init completes, the reloader switches to the real cert and behaves like the one returned by Init.
+// The result of Init is delivered on the returned channel, which is closed afterwards.
+// If Init fails, the reloader keeps serving the fallback cert.
+func InitWithFallback(cfg *Config, cleanup func()) (*CertReloader, <-chan error, error) {
+	// apply environment overrides and validate config
+	applyEnv(cfg)
+	err := CheckConfig(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// issuing the fallback cert needs the domains and key type
+	lockConfig()
+	setConfig(cfg)
+	certPEM, keyPEM, err := issueLocalCert(nil, nil)
+	unlockConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("simplecert: failed to create fallback cert: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("simplecert: failed to load fallback cert: %w", err)
+	}
+
+	reloader := newCertReloader()
+	reloader.cert = &cert
+
+	log.Println("[INFO] simplecert: serving a self signed fallback cert for", cfg.Domains, "until the real cert is ready")
+
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(errChan)
+
+		lockConfig()
+		_, err := initReloader(cfg, cleanup, reloader)
+		unlockConfig()
+		if err != nil {
+			log.Println("[ERROR] simplecert: failed to init, keeping the fallback cert: ", err)
+		}
+		errChan <- err
+	}()
+
+	return reloader, errChan, nil
+}
+
+// startReloader starts reloader with the cert at certPath and keyPath
+// or creates a new CertReloader if reloader is nil
+func startReloader(reloader *CertReloader, certPath, keyPath string, logFile *os.File, cleanup func()) (*CertReloader, error) {
+	if reloader == nil {
+		return NewCertReloader(certPath, keyPath, logFile, cleanup)
+	}
+
+	err := reloader.start(certPath, keyPath, logFile, cleanup)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
@@ -0,0 +1,168 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+// InitGroups obtains or loads a cert for each of the CertGroups in cfg and returns a CertReloader per group,
+// in the order of the groups. Each cert is stored in a subfolder of the CacheDir,
+// named after a hash of the domains of the group, and renewed independently.
+// All groups share the remaining configuration and the ACME account.
+// The certs are obtained one at a time, obtaining a cert uses the global config
+// and the standalone challenge servers of all groups bind the same ports.
+// If there are no CertGroups, but more Domains than fit into a single cert, the Domains are split into groups,
+// derived from a hash of each domain, so changing a few domains does not cause the other certs to be reissued.
+// The cleanup func is called once when a syscall.SIGINT or syscall.SIGABRT is received.
+func InitGroups(cfg *Config, cleanup func()) ([]*CertReloader, error) {
+	applyEnv(cfg)
+
+	groups := cfg.CertGroups
+	if len(groups) == 0 {
+		if len(domainSet(cfg.Domains)) <= cfg.maxDomainsPerCert() {
+			return nil, errNoCertGroups
+		}
+		groups = splitDomains(cfg.Domains, cfg.maxDomainsPerCert())
+	}
+	if cfg.CacheDir == "" {
+		return nil, errNoCacheDir
+	}
+
+	// the renewal routines of the groups wait until all groups are initialized
+	lockConfig()
+	defer unlockConfig()
+
+	var reloaders []*CertReloader
+	for i, domains := range groups {
+		group := *cfg
+		group.CertGroups = nil
+		group.Domains = domains
+		group.CacheDir = filepath.Join(cfg.CacheDir, groupDirName(domains))
+
+		// share the account and the logfile
+		group.AccountStorageDir = cfg.accountDir()
+		if i > 0 {
+			group.LogFile = ""
+		} else if group.LogFile != "" && !filepath.IsAbs(group.LogFile) {
+			group.LogFile = filepath.Join(cfg.CacheDir, group.LogFile)
+		}
+
+		// only one reloader handles the termination signals
+		groupCleanup := cleanup
+		if i > 0 {
+			groupCleanup = func() {}
+		}
+
+		reloader := newCertReloader()
+		_, err := initReloader(&group, groupCleanup, reloader)
+		if err != nil {
+			for _, r := range reloaders {
+				r.Close()
+			}
+			return nil, fmt.Errorf("simplecert: failed to init cert group %v: %w", domains, err)
+		}
+		reloaders = append(reloaders, reloader)
+	}
+
+	return reloaders, nil
+}
+
+// defaultMaxDomainsPerCert is the number of SANs Let's Encrypt allows in a single cert
+const defaultMaxDomainsPerCert = 100
+
+// ErrTooManyDomains is returned by CheckConfig if more domains are configured than the CA allows in a single cert.
+// Use InitGroups to obtain several certs instead.
+type ErrTooManyDomains struct {
+	Domains int
+	Limit   int
+}
+
+func (e *ErrTooManyDomains) Error() string {
+	return fmt.Sprintf("simplecert: %d domains exceed the limit of %d domains per cert, use InitGroups to split them into several certs", e.Domains, e.Limit)
+}
+
+// splitDomains distributes the domains into the least number of groups with at most limit domains.
+// Each domain is placed into a group derived from its hash, or the next one with space left,
+// so adding or removing a domain hardly moves the other domains as long as the number of groups stays the same.
+func splitDomains(domains []string, limit int) [][]string {
+	set := domainSet(domains)
+	groups := make([][]string, (len(set)+limit-1)/limit)
+
+	for _, d := range set {
+		h := fnv.New32a()
+		h.Write([]byte(d))
+
+		for i := int(h.Sum32() % uint32(len(groups))); ; i = (i + 1) % len(groups) {
+			if len(groups[i]) < limit {
+				groups[i] = append(groups[i], d)
+				break
+			}
+		}
+	}
+
+	return groups
+}
+
+// groupDirName returns the name of the subfolder of a cert group, derived from its domains
+func groupDirName(domains []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(domainSet(domains), ",")))
+	return "group-" + hex.EncodeToString(sum[:8])
+}
+
+// outerConfig is the global config replaced by useConfig, protected by configMu
+var outerConfig *Config
+
+// useConfig locks configMu and makes cfg the global config until the returned func is called
+func useConfig(cfg *Config) (restore func()) {
+	lockConfig()
+	outerConfig = c
+	setConfig(cfg)
+	return func() {
+		setConfig(outerConfig)
+		outerConfig = nil
+		unlockConfig()
+	}
+}
+
+// withoutConfigLock calls the user hook fn with configMu released,
+// so the hook can call Init, Revoke and the other functions locking it.
+// The caller must hold configMu, the config of the caller is in effect again when it returns.
+func withoutConfigLock(fn func()) {
+	cfg, outer := c, outerConfig
+	if outer != nil {
+		// publish the global config again while the renewal is paused,
+		// it might be replaced by the hook
+		setConfig(outer)
+		outerConfig = nil
+	}
+
+	// the deferred callbacks stay with the caller, it might hold the cacheDir lock
+	hooksMu.Lock()
+	hooks := pendingHooks
+	pendingHooks = nil
+	configLocked = false
+	hooksMu.Unlock()
+	configMu.Unlock()
+
+	defer func() {
+		lockConfig()
+		hooksMu.Lock()
+		pendingHooks = append(hooks, pendingHooks...)
+		hooksMu.Unlock()
+		if outer != nil {
+			outerConfig = c
+		}
+		setConfig(cfg)
+	}()
+
+	fn()
+}
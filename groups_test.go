@@ -0,0 +1,60 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSplitDomains(t *testing.T) {
+	var domains []string
+	for i := 0; i < 250; i++ {
+		domains = append(domains, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	groups := splitDomains(domains, 100)
+	if len(groups) != 3 {
+		t.Fatalf("expected 3 groups, got %d", len(groups))
+	}
+	seen := make(map[string]bool)
+	for _, group := range groups {
+		if len(group) > 100 {
+			t.Errorf("group exceeds the limit with %d domains", len(group))
+		}
+		for _, d := range group {
+			seen[d] = true
+		}
+	}
+	if len(seen) != len(domains) {
+		t.Errorf("expected all %d domains in the groups, got %d", len(domains), len(seen))
+	}
+
+	// the order of the domains does not matter
+	reversed := make([]string, len(domains))
+	for i, d := range domains {
+		reversed[len(domains)-1-i] = d
+	}
+	if !reflect.DeepEqual(splitDomains(reversed, 100), groups) {
+		t.Error("expected the same groups for the same domains")
+	}
+
+	// removing a domain keeps the others in their groups
+	index := make(map[string]int)
+	for i, group := range groups {
+		for _, d := range group {
+			index[d] = i
+		}
+	}
+	for i, group := range splitDomains(domains[1:], 100) {
+		for _, d := range group {
+			if index[d] != i {
+				t.Errorf("%s moved from group %d to %d", d, index[d], i)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+)
+
+// the entries added by simplecert are placed between these lines in the hosts file
+const (
+	hostsFenceStart = "# simplecert start"
+	hostsFenceEnd   = "# simplecert end"
+)
+
+// updateHosts is used in local mode
+// to add all host entries for the domains
+// entries are only added if the domain does not already map to localhost,
+// so calling it repeatedly does not modify the hosts file again.
+// the path of the hosts file and its line endings depend on the OS, see hosts_unix.go and hosts_windows.go
+func updateHosts() error {
+	return editHosts(func(outside, fenced []string) []string {
+		for _, d := range c.asciiDomains() {
+			// hosts files can neither resolve wildcards nor IP addresses
+			if strings.HasPrefix(d, "*.") || net.ParseIP(d) != nil {
+				continue
+			}
+			if hostsHas(outside, localhost, d) || hostsHas(fenced, localhost, d) {
+				continue
+			}
+			fenced = append(fenced, localhost+" "+d)
+		}
+		return fenced
+	})
+}
+
+// RemoveHosts removes the entries for the domains of cfg that simplecert added to the hosts file in local mode.
+// Entries outside of the section added by simplecert are not modified.
+func RemoveHosts(cfg *Config) error {
+	return editHosts(func(outside, fenced []string) []string {
+		var keep []string
+		for _, line := range fenced {
+			if !hostsLineHasAny(line, cfg.asciiDomains()) {
+				keep = append(keep, line)
+			}
+		}
+		return keep
+	})
+}
+
+// editHosts reads the hosts file, passes the lines outside and inside the simplecert section to edit
+// and writes the section returned by edit back, the file is only written if it changed
+func editHosts(edit func(outside, fenced []string) []string) error {
+	data, err := os.ReadFile(hostsFilePath)
+	if err != nil {
+		return fmt.Errorf("simplecert: could not read hosts file: %s", err)
+	}
+
+	outside, fenced := splitHosts(string(data))
+	fenced = edit(outside, fenced)
+
+	lines := outside
+	if len(fenced) > 0 {
+		lines = append(append(append(lines, hostsFenceStart), fenced...), hostsFenceEnd)
+	}
+
+	updated := strings.Join(lines, hostsEOL) + hostsEOL
+	if updated == string(data) {
+		return nil
+	}
+
+	// write in place instead of renaming, the hosts file might be a bind mount (e.g. in docker containers)
+	err = os.WriteFile(hostsFilePath, []byte(updated), 0644)
+	if err != nil {
+		if os.IsPermission(err) {
+			return fmt.Errorf("simplecert: no permission to modify the hosts file %s, %s", hostsFilePath, hostsPermissionHint)
+		}
+		return fmt.Errorf("simplecert: could not update the hosts file %s: %s", hostsFilePath, err)
+	}
+
+	log.Println("[INFO] simplecert: updated hosts file", hostsFilePath)
+
+	return nil
+}
+
+// splitHosts splits the content of a hosts file into the lines outside and inside of the simplecert section
+// trailing empty lines are dropped, and CRLF line endings are normalized
+func splitHosts(content string) (outside, fenced []string) {
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.TrimSpace(line) == hostsFenceStart:
+			inFence = true
+		case strings.TrimSpace(line) == hostsFenceEnd:
+			inFence = false
+		case inFence:
+			if strings.TrimSpace(line) != "" {
+				fenced = append(fenced, line)
+			}
+		default:
+			outside = append(outside, line)
+		}
+	}
+
+	for len(outside) > 0 && strings.TrimSpace(outside[len(outside)-1]) == "" {
+		outside = outside[:len(outside)-1]
+	}
+
+	return outside, fenced
+}
+
+// hostsHas reports whether any of the lines maps host to ip
+func hostsHas(lines []string, ip, host string) bool {
+	for _, line := range lines {
+		fields := hostsFields(line)
+		if len(fields) < 2 || fields[0] != ip {
+			continue
+		}
+		for _, h := range fields[1:] {
+			if strings.EqualFold(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostsLineHasAny reports whether the line contains any of the hosts
+func hostsLineHasAny(line string, hosts []string) bool {
+	fields := hostsFields(line)
+	if len(fields) < 2 {
+		return false
+	}
+	for _, h := range fields[1:] {
+		for _, host := range hosts {
+			if strings.EqualFold(h, host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostsFields returns the fields of a hosts file line without comments
+func hostsFields(line string) []string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.Fields(line)
+}
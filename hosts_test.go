@@ -0,0 +1,62 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateAndRemoveHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	original := "127.0.0.1 localhost" + hostsEOL + "10.0.0.1 example.com" + hostsEOL
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hostsPath := hostsFilePath
+	hostsFilePath = path
+	setConfig(&Config{Domains: []string{"example.com", "local.example.com", "*.example.com"}})
+	defer func() {
+		hostsFilePath = hostsPath
+		setConfig(nil)
+	}()
+
+	// repeated updates add the entries once
+	for i := 0; i < 2; i++ {
+		if err := updateHosts(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "local.example.com"); n != 1 {
+		t.Fatalf("expected a single entry for local.example.com, got %d:\n%s", n, data)
+	}
+	if strings.Contains(string(data), "*.example.com") {
+		t.Fatalf("unexpected wildcard entry:\n%s", data)
+	}
+	if !strings.HasPrefix(string(data), original) {
+		t.Fatalf("existing entries have been modified:\n%s", data)
+	}
+
+	// the entries outside of the simplecert section are kept, even if they contain a removed domain
+	if err := RemoveHosts(c); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Fatalf("expected the original hosts file, got:\n%s", data)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+const (
+	hostsEOL            = "\n"
+	hostsPermissionHint = "run as root or disable UpdateHosts and add the entries manually"
+)
+
+// tests can replace it with a temporary file
+var hostsFilePath = "/etc/hosts"
@@ -0,0 +1,21 @@
+//go:build windows
+
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// the hosts file on windows uses CRLF line endings
+const (
+	hostsEOL            = "\r\n"
+	hostsPermissionHint = "run as administrator or disable UpdateHosts and add the entries manually"
+)
+
+var hostsFilePath = filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
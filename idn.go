@@ -0,0 +1,52 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// asciiDomain returns the ASCII (punycode) form of an internationalized domain name like münchen.example,
+// as expected by the CA and in certificates. ASCII domains, wildcards and IP addresses are returned unchanged.
+func asciiDomain(domain string) (string, error) {
+	if isASCII(domain) || net.ParseIP(domain) != nil {
+		return domain, nil
+	}
+
+	if rest, ok := strings.CutPrefix(domain, "*."); ok {
+		ascii, err := idna.Lookup.ToASCII(rest)
+		return "*." + ascii, err
+	}
+	return idna.Lookup.ToASCII(domain)
+}
+
+// asciiDomains returns the ASCII form of the configured domains.
+// The configured domains are kept in their Unicode form for display in logs.
+func (c *Config) asciiDomains() []string {
+	domains := make([]string, len(c.Domains))
+	for i, d := range c.Domains {
+		// invalid domains are rejected by CheckConfig
+		ascii, err := asciiDomain(d)
+		if err != nil {
+			ascii = d
+		}
+		domains[i] = ascii
+	}
+	return domains
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestASCIIDomain(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "example.com"},
+		{"münchen.example", "xn--mnchen-3ya.example"},
+		{"*.MÜNCHEN.example", "*.xn--mnchen-3ya.example"},
+		{"127.0.0.1", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		got, err := asciiDomain(tt.domain)
+		if err != nil {
+			t.Errorf("%s: %v", tt.domain, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestIDNLocalCert(t *testing.T) {
+	setConfig(&Config{Domains: []string{"münchen.example", "www.münchen.example"}, KeyType: EC256})
+	defer setConfig(nil)
+
+	certPEM, _, err := issueLocalCert(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDomains(cert.DNSNames, []string{"xn--mnchen-3ya.example", "www.xn--mnchen-3ya.example"}) {
+		t.Fatalf("expected the punycode domains in the cert, got %v", cert.DNSNames)
+	}
+
+	// the cert matches the configured Unicode domains
+	certPath := filepath.Join(t.TempDir(), certFileName)
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if domainsChanged(certPath, "") {
+		t.Error("expected domains to be unchanged")
+	}
+}
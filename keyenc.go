@@ -0,0 +1,140 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// encryptedKeyBlockType is the PEM type of a key encrypted with the KeyEncryptionPassphrase
+	encryptedKeyBlockType = "SIMPLECERT ENCRYPTED PRIVATE KEY"
+
+	keySaltSize = 16
+
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var errNoKeyPassphrase = errors.New("simplecert: key is encrypted, but no KeyEncryptionPassphrase is configured")
+
+// sealKey encrypts the PEM encoded key with the KeyEncryptionPassphrase.
+// The key is returned unchanged if no passphrase is configured.
+func (c *Config) sealKey(keyPEM []byte) ([]byte, error) {
+	if c == nil || len(c.KeyEncryptionPassphrase) == 0 || len(keyPEM) == 0 {
+		return keyPEM, nil
+	}
+
+	salt := make([]byte, keySaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("simplecert: failed to generate salt: %w", err)
+	}
+
+	aead, err := keyCipher(c.KeyEncryptionPassphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("simplecert: failed to generate nonce: %w", err)
+	}
+
+	// the block contains the salt, the nonce and the sealed key
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, keyPEM, nil)
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedKeyBlockType,
+		Headers: map[string]string{
+			"KDF":    "scrypt",
+			"Cipher": "AES-256-GCM",
+		},
+		Bytes: data,
+	}), nil
+}
+
+// openKey decrypts a key sealed with the KeyEncryptionPassphrase and returns the PEM encoded key.
+// Plaintext keys are returned unchanged, so keys written before enabling the encryption can still be loaded.
+func (c *Config) openKey(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedKeyBlockType {
+		return data, nil
+	}
+	if c == nil || len(c.KeyEncryptionPassphrase) == 0 {
+		return nil, errNoKeyPassphrase
+	}
+
+	if len(block.Bytes) < keySaltSize {
+		return nil, errors.New("simplecert: encrypted key is truncated")
+	}
+	salt, data := block.Bytes[:keySaltSize], block.Bytes[keySaltSize:]
+
+	aead, err := keyCipher(c.KeyEncryptionPassphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("simplecert: encrypted key is truncated")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+
+	keyPEM, err := aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to decrypt key, wrong KeyEncryptionPassphrase?: %w", err)
+	}
+	return keyPEM, nil
+}
+
+// keyCipher derives the AES-256-GCM cipher for the passphrase and salt
+func keyCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to derive key encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadKeyPair reads the cert and key from disk like tls.LoadX509KeyPair,
+// decrypting the key with the KeyEncryptionPassphrase of cfg if necessary
+func loadKeyPair(cfg *Config, certPath, keyPath string) (tls.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err = cfg.openKey(keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// worldAccessible reports whether other users can access the directory at path
+func worldAccessible(path string, perm os.FileMode) bool {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return perm&0007 != 0
+}
@@ -0,0 +1,116 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyEncryption(t *testing.T) {
+	dir := t.TempDir()
+	setConfig(&Config{
+		CacheDir:                dir,
+		CacheDirPerm:            0700,
+		KeyEncryptionPassphrase: []byte("secret"),
+	})
+	defer setConfig(nil)
+
+	cert := testCertResource(t, time.Hour)
+	if err := saveCertToDisk(cert, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key must not be stored in plaintext
+	for _, name := range []string{keyFileName, certResourceFileName} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("PRIVATE KEY-----")) && !bytes.Contains(data, []byte(encryptedKeyBlockType)) {
+			t.Fatalf("%s contains a plaintext key", name)
+		}
+	}
+
+	// it is decrypted when loaded
+	cr, err := readCertResource(c, filepath.Join(dir, certResourceFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cr.PrivateKey, cert.PrivateKey) {
+		t.Fatal("decrypted key does not match")
+	}
+	if err := checkKeyPair(filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a wrong or missing passphrase fails
+	c.KeyEncryptionPassphrase = []byte("wrong")
+	if err := checkKeyPair(filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName)); err == nil {
+		t.Fatal("expected an error for a wrong passphrase")
+	}
+	c.KeyEncryptionPassphrase = nil
+	if _, err := readCertResource(c, filepath.Join(dir, certResourceFileName)); !errors.Is(err, errNoKeyPassphrase) {
+		t.Fatalf("got %v, want %v", err, errNoKeyPassphrase)
+	}
+
+	// the key is decrypted with the config of the caller, not the global one
+	if _, err := readCertResource(&Config{KeyEncryptionPassphrase: []byte("secret")}, filepath.Join(dir, certResourceFileName)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalKeyEncryption(t *testing.T) {
+	dir := t.TempDir()
+	setConfig(&Config{
+		CacheDir:                dir,
+		CacheDirPerm:            0700,
+		Domains:                 []string{"example.com"},
+		KeyType:                 EC256,
+		KeyEncryptionPassphrase: []byte("secret"),
+	})
+	defer setConfig(nil)
+
+	certPath, keyPath := filepath.Join(dir, certFileName), filepath.Join(dir, keyFileName)
+	createLocalCert(certPath, keyPath)
+
+	for _, path := range []string{keyPath, filepath.Join(dir, rootCAKeyFileName)} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(data, []byte(encryptedKeyBlockType)) {
+			t.Fatalf("%s is not encrypted", path)
+		}
+	}
+
+	// the cert and the CA are loaded again
+	if err := checkKeyPair(certPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ensureLocalCA(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenPlaintextKey(t *testing.T) {
+	cert := testCertResource(t, time.Hour)
+
+	// plaintext keys are loaded with and without a passphrase
+	for _, cfg := range []*Config{nil, {KeyEncryptionPassphrase: []byte("secret")}} {
+		key, err := cfg.openKey(cert.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, cert.PrivateKey) {
+			t.Fatal("plaintext key has been modified")
+		}
+	}
+}
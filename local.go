@@ -6,84 +6,320 @@
 package simplecert
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"log"
+	"math/big"
+	"net"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/goodhosts/hostsfile"
+	"github.com/go-acme/lego/v4/certcrypto"
 )
 
-// updateHosts is used in local mode
-// to add all host entries for the domains
-func updateHosts() {
-	// get hostfile handle
-	hosts, err := hostsfile.NewHosts()
+// validity of local certs if no LocalValidity has been configured
+const defaultLocalValidity = 365 * 24 * time.Hour
+
+// local certs expiring within this period are regenerated on Init
+const localRenewBefore = 7 * 24 * time.Hour
+
+// file names of the local root CA, identical to the ones used by mkcert
+const (
+	rootCAFileName    = "rootCA.pem"
+	rootCAKeyFileName = "rootCA-key.pem"
+)
+
+// createLocalCert first makes sure the local root CA exists
+// and then issues a certificate signed by that CA for the domains specified in the configuration
+func createLocalCert(certFilePath, keyFilePath string) {
+	log.Println("[INFO] no cached cert found. Creating a new one for local development...")
+
+	caCert, caKey, err := ensureLocalCA()
 	if err != nil {
-		log.Fatal("[FATAL] simplecert: could not open hostsfile: ", err)
+		log.Fatal("[FATAL] simplecert: failed to setup local root CA: ", err)
 	}
 
-	// check if all domains from config are present
-	for _, d := range c.Domains {
-		if !hosts.Has(localhost, d) {
-			hosts.Add(localhost, d)
-		}
+	certPEM, keyPEM, err := issueLocalCert(caCert, caKey)
+	if err != nil {
+		log.Fatal("[FATAL] simplecert: failed to create local cert: ", err)
 	}
 
-	// write changes to disk
-	if err := hosts.Flush(); err != nil {
-		log.Fatal("[FATAL] simplecert: could not update /etc/hosts: ", err)
+	err = writeFileAtomic(certFilePath, certPEM, c.CacheDirPerm)
+	if err != nil {
+		log.Fatal("[FATAL] simplecert: failed to write cert file: ", err)
 	}
+
+	keyPEM, err = c.sealKey(keyPEM)
+	if err != nil {
+		log.Fatal("[FATAL] simplecert: failed to encrypt key: ", err)
+	}
+	err = writeFileAtomic(keyFilePath, keyPEM, c.CacheDirPerm)
+	if err != nil {
+		log.Fatal("[FATAL] simplecert: failed to write key file: ", err)
+	}
+
+	log.Println("[INFO] simplecert: created local cert for", c.Domains, "valid until", time.Now().Add(localValidity()).Format(time.RFC1123))
 }
 
-// createLocalCert first creates a local root CA for mkcert
-// and then generates a trusted certificate for the domains specified in the configuration
-func createLocalCert(certFilePath, keyFilePath string) {
-	log.Println("[INFO] no cached cert found. Creating a new one for local development...")
+// LocalCACertPath returns the path of the root CA certificate used to sign the certificates in local mode.
+// Install it once into the trust store of your OS or browser to trust all certificates simplecert creates for local development.
+func LocalCACertPath(cfg *Config) string {
+	return filepath.Join(localCacheDir(cfg), rootCAFileName)
+}
+
+// localCacheDir returns the folder the local certs of cfg are stored in
+func localCacheDir(cfg *Config) string {
+	// Init moves the CacheDir into the local subfolder
+	if cfg.localDir != "" {
+		return cfg.localDir
+	}
+	return filepath.Join(cfg.CacheDir, "local")
+}
+
+// ensureLocalCA loads the root CA from the cacheDir, or creates a new one if there is none.
+// A new CA will be installed into the system trust store with mkcert, if InstallLocalCA is set and mkcert is available.
+func ensureLocalCA() (*x509.Certificate, crypto.Signer, error) {
+	var (
+		certPath = filepath.Join(c.CacheDir, rootCAFileName)
+		keyPath  = filepath.Join(c.CacheDir, rootCAKeyFileName)
+	)
+
+	// reuse the existing CA, so the trust store entry stays valid
+	_, errCert := os.Stat(certPath)
+	_, errKey := os.Stat(keyPath)
+	if errCert == nil && errKey == nil {
+		return loadLocalCA(certPath, keyPath)
+	}
+
+	log.Println("[INFO] simplecert: creating a new local root CA at", certPath)
+
+	certPEM, keyPEM, err := createLocalCA()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = writeFileAtomic(certPath, certPEM, c.CacheDirPerm); err != nil {
+		return nil, nil, err
+	}
+	if keyPEM, err = c.sealKey(keyPEM); err != nil {
+		return nil, nil, err
+	}
+	if err = writeFileAtomic(keyPath, keyPEM, c.CacheDirPerm); err != nil {
+		return nil, nil, err
+	}
+
+	if c.InstallLocalCA {
+		installLocalCA()
+	} else {
+		log.Println("[INFO] simplecert: add", certPath, "to your trust store to trust the local certs")
+	}
+
+	return loadLocalCA(certPath, keyPath)
+}
+
+// createLocalCA generates a new self signed root CA and returns certificate and key PEM encoded
+func createLocalCA() (certPEM []byte, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"simplecert development CA"},
+			CommonName:   "simplecert development CA",
+		},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
+
+// installLocalCA adds the local root CA to the system trust store with mkcert.
+// mkcert uses the same file names for its CA, but can not read a key encrypted with the KeyEncryptionPassphrase.
+// It only needs the certificate for installing the CA, so CAROOT is pointed to a temporary directory
+// containing nothing but a copy of the certificate.
+// If mkcert is not installed, the user is asked to install the CA manually.
+func installLocalCA() {
+	certPath := filepath.Join(c.CacheDir, rootCAFileName)
+	if _, err := exec.LookPath("mkcert"); err != nil {
+		log.Println("[WARNING] simplecert: mkcert not found, please add", certPath, "to your trust store manually")
+		return
+	}
+
 	log.Println("[INFO] please note that for this cert to be trusted by firefox or nodejs additional steps are necessary!")
 	log.Println("[INFO] see instructions at https://github.com/FiloSottile/mkcert")
 
-	// run mkcert to create root CA
-	runCommand("mkcert", "-install")
+	caRoot, err := os.MkdirTemp("", "simplecert-caroot")
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to create CAROOT for mkcert: ", err)
+		return
+	}
+	defer os.RemoveAll(caRoot)
 
-	// run mkcert to generate the certificate
-	runCommand("mkcert", c.Domains...)
+	err = copyFile(certPath, filepath.Join(caRoot, rootCAFileName))
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to copy local root CA into CAROOT for mkcert: ", err)
+		return
+	}
 
-	var (
-		newCertFile string
-		newKeyFile  string
+	cmd := exec.Command("mkcert", "-install")
+	cmd.Env = append(os.Environ(), "CAROOT="+caRoot)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to install local root CA: ", err, ", output: ", string(out))
+	}
+}
 
-		firstDomain = c.Domains[0]
-	)
+// localValidity returns the configured validity for local certs
+func localValidity() time.Duration {
+	if c.LocalValidity > 0 {
+		return c.LocalValidity
+	}
+	return defaultLocalValidity
+}
+
+// loadLocalCA reads the root CA certificate and private key in PEM format
+func loadLocalCA(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, errors.New("failed to decode CA certificate PEM")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if strings.HasPrefix(firstDomain, "*") {
-		firstDomain = strings.TrimPrefix(firstDomain, "*")
-		firstDomain = "_wildcard" + firstDomain
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = c.openKey(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, errors.New("failed to decode CA key PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, errors.New("CA key is not a signing key")
 	}
 
-	if len(c.Domains) > 1 {
-		newCertFile = firstDomain + "+" + strconv.Itoa(len(c.Domains)-1) + ".pem"
-		newKeyFile = firstDomain + "+" + strconv.Itoa(len(c.Domains)-1) + "-key.pem"
-	} else {
-		newCertFile = firstDomain + ".pem"
-		newKeyFile = firstDomain + "-key.pem"
+	return caCert, signer, nil
+}
+
+// issueLocalCert creates a new private key and a certificate signed by the CA
+// for the configured domains and IP addresses, and returns both PEM encoded.
+// If caCert is nil, the certificate is self signed.
+func issueLocalCert(caCert *x509.Certificate, caKey crypto.Signer) (certPEM []byte, keyPEM []byte, err error) {
+	// use the configured key type, like a cert obtained from the CA
+	privateKey, err := certcrypto.GeneratePrivateKey(certcrypto.KeyType(c.KeyType))
+	if err != nil {
+		return nil, nil, err
+	}
+	key := privateKey.(crypto.Signer)
+
+	// RSA keys are used for key encipherment as well
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// certificates contain the ASCII form of internationalized domains
+	domains := c.asciiDomains()
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"simplecert development certificate"},
+			CommonName:   domains[0],
+		},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(localValidity()),
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	// IP addresses in the domain list end up as IP SANs
+	for _, d := range domains {
+		if ip := net.ParseIP(d); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, d)
+		}
 	}
+	template.IPAddresses = append(template.IPAddresses, localIPs()...)
 
-	// rename certificate file
-	log.Println("[INFO] renaming", newCertFile, "to", certFilePath)
-	err := os.Rename(newCertFile, certFilePath)
+	// without a CA the cert signs itself
+	parent, signer := caCert, caKey
+	if caCert == nil {
+		parent, signer = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
 	if err != nil {
-		log.Fatal("[FATAL] simplecert: failed to rename cert file: ", err)
+		return nil, nil, err
 	}
 
-	// rename key file
-	log.Println("[INFO] renaming", newKeyFile, "to", keyFilePath)
-	err = os.Rename(newKeyFile, keyFilePath)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
 	if err != nil {
-		log.Fatal("[FATAL] simplecert: failed to rename key file: ", err)
+		return nil, nil, err
 	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
 }
 
 // domainsChanged check the stored domains when running in local mode
@@ -108,31 +344,125 @@ func domainsChanged(certFilePath, keyFilePath string) bool {
 		log.Fatal("[FATAL] simplecert could not load X509 key pair: ", err)
 	}
 
-	// log.Println("[INFO] domains in cert: ", cert.DNSNames)
-	// log.Println("[INFO] domains in config: ", c.Domains)
+	// compare the domains as sets
+	// the order and duplicate entries are not relevant
+	// since letsencrypt issues certs for a set of domains
+	expected := append([]string{}, c.Domains...)
+	if c.Local {
+		// local certs contain the LocalIPs as well
+		for _, ip := range localIPs() {
+			expected = append(expected, ip.String())
+		}
+	}
 
-	// if the number of entries is not equal, bail out.
-	if len(cert.DNSNames) != len(c.Domains) {
-		log.Println("[ERROR] len(cert.DNSNames):", cert.DNSNames, "!=", "len(c.Domains):", c.Domains)
+	if !sameDomains(certNames(cert), expected) {
+		log.Println("[INFO] domains in cert:", certNames(cert), "do not match domains in config:", expected)
 		return true
 	}
 
-	// check if all entries match
-	// the order of entries is not relevant
-	// since letsencrypt issues certs for a set of domains
-	for _, d := range cert.DNSNames {
-		var found bool
-		for _, dd := range c.Domains {
-			if d == dd {
-				found = true
-			}
+	// identical
+	return false
+}
+
+// localIPs returns the additional IP addresses for local certs:
+// the LocalIPs and the loopback addresses, if localhost is among the domains
+func localIPs() []net.IP {
+	ips := append([]net.IP{}, c.LocalIPs...)
+	for _, d := range c.Domains {
+		if strings.EqualFold(d, "localhost") {
+			ips = append(ips, net.IPv4(127, 0, 0, 1), net.IPv6loopback)
+			break
 		}
-		if !found {
-			log.Println("[ERROR] could not find domain", d, "in c.Domains:", c.Domains)
-			return true
+	}
+	return ips
+}
+
+// localKeyTypeChanged reports whether the key of the local cert does not match the configured KeyType
+func localKeyTypeChanged(certFilePath string) bool {
+	data, err := os.ReadFile(certFilePath)
+	if err != nil {
+		return true
+	}
+	certs, err := parsePEMBundle(data)
+	if err != nil {
+		return true
+	}
+
+	var keyType string
+	switch pub := certs[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		keyType = strconv.Itoa(pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		keyType = "P" + strconv.Itoa(pub.Curve.Params().BitSize)
+	}
+
+	return keyType != c.KeyType
+}
+
+// localCertExpiring reports whether the local cert expires within localRenewBefore
+func localCertExpiring(certFilePath string) bool {
+	data, err := os.ReadFile(certFilePath)
+	if err != nil {
+		return true
+	}
+	certs, err := parsePEMBundle(data)
+	if err != nil {
+		return true
+	}
+
+	return time.Until(certs[0].NotAfter) < localRenewBefore
+}
+
+// certNames returns the DNS names and IP addresses the certificate is valid for
+func certNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// sameDomains reports whether a and b contain the same set of domains, ignoring case and order.
+// Wildcard entries like *.example.com are matched literally against wildcard entries,
+// a wildcard does not cover a plain domain, since the CA issues a SAN for every configured name.
+func sameDomains(a, b []string) bool {
+	setA, setB := domainSet(a), domainSet(b)
+	if len(setA) != len(setB) {
+		return false
+	}
+	for i := range setA {
+		if setA[i] != setB[i] {
+			return false
 		}
 	}
+	return true
+}
 
-	// identical
-	return false
+// domainSet returns the sorted and deduplicated list of domains
+// domains are normalized to lowercase and a trailing dot (FQDN notation) is removed
+func domainSet(domains []string) []string {
+	var (
+		set  = make([]string, 0, len(domains))
+		seen = make(map[string]bool, len(domains))
+	)
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(d), ".")
+
+		// compare internationalized domains in their ASCII form, as found in certs
+		if ascii, err := asciiDomain(d); err == nil {
+			d = ascii
+		}
+
+		// use the canonical form of IP addresses
+		if ip := net.ParseIP(d); ip != nil {
+			d = ip.String()
+		}
+		if seen[d] {
+			continue
+		}
+		seen[d] = true
+		set = append(set, d)
+	}
+	sort.Strings(set)
+	return set
 }
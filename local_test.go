@@ -0,0 +1,154 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameDomains(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{"identical", []string{"example.com", "www.example.com"}, []string{"example.com", "www.example.com"}, true},
+		{"reordered", []string{"example.com", "www.example.com"}, []string{"www.example.com", "example.com"}, true},
+		{"case", []string{"Example.COM", "www.example.com"}, []string{"example.com", "WWW.Example.com"}, true},
+		{"trailing dot", []string{"example.com.", "www.example.com"}, []string{"example.com", "www.example.com."}, true},
+		{"duplicates", []string{"example.com", "example.com"}, []string{"example.com"}, true},
+		{"wildcard", []string{"*.example.com", "example.com"}, []string{"example.com", "*.Example.com"}, true},
+		{"wildcard does not cover domain", []string{"*.example.com"}, []string{"www.example.com"}, false},
+		{"added", []string{"example.com"}, []string{"example.com", "www.example.com"}, false},
+		{"removed", []string{"example.com", "www.example.com"}, []string{"example.com"}, false},
+		{"replaced", []string{"example.com", "www.example.com"}, []string{"example.com", "api.example.com"}, false},
+		{"ip", []string{"::1", "127.0.0.1"}, []string{"127.0.0.1", "0:0::1"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameDomains(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameDomains(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainsChanged(t *testing.T) {
+	dir := t.TempDir()
+	certPath := writeTestCert(t, dir, "www.example.com", "example.com")
+
+	setConfig(&Config{})
+	defer setConfig(nil)
+
+	c.Domains = []string{"EXAMPLE.com.", "www.example.com"}
+	if domainsChanged(certPath, "") {
+		t.Error("expected domains to be unchanged")
+	}
+
+	c.Domains = []string{"example.com", "www.example.com", "api.example.com"}
+	if !domainsChanged(certPath, "") {
+		t.Error("expected domains to be changed")
+	}
+}
+
+func TestLocalCertExpiring(t *testing.T) {
+	dir := t.TempDir()
+
+	// the test cert is valid for one hour
+	if !localCertExpiring(writeTestCert(t, dir, "example.com")) {
+		t.Error("expected cert to be expiring")
+	}
+	if !localCertExpiring(filepath.Join(dir, "missing.pem")) {
+		t.Error("expected missing cert to be treated as expiring")
+	}
+}
+
+// writeTestCert writes a self signed cert for the domains into dir and returns its path
+func writeTestCert(t *testing.T, dir string, domains ...string) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, certFileName)
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestInstallLocalCAWithoutKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake mkcert is a shell script")
+	}
+
+	// the fake mkcert records the files in its CAROOT
+	bin := t.TempDir()
+	listing := filepath.Join(t.TempDir(), "caroot")
+	script := "#!/bin/sh\nls \"$CAROOT\" > " + listing + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "mkcert"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	setConfig(&Config{CacheDir: dir, CacheDirPerm: 0700, KeyEncryptionPassphrase: []byte("secret")})
+	defer setConfig(nil)
+
+	certPEM, keyPEM, err := createLocalCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyPEM, err = c.sealKey(keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, rootCAFileName), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, rootCAKeyFileName), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	installLocalCA()
+
+	// mkcert can not read the encrypted key, it must only see the certificate
+	files, err := os.ReadFile(listing)
+	if err != nil {
+		t.Fatal("mkcert has not been called: ", err)
+	}
+	if got := strings.TrimSpace(string(files)); got != rootCAFileName {
+		t.Fatalf("CAROOT contains %q, want only %s", got, rootCAFileName)
+	}
+}
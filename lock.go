@@ -0,0 +1,49 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const lockFileName = "lock"
+
+// cacheLock is an advisory lock on the cacheDir
+// it is used to make sure that only a single process sharing the cacheDir
+// obtains or renews a certificate at a time
+type cacheLock struct {
+	f *os.File
+}
+
+// lockCacheDir acquires the lock for the cacheDir
+// and blocks until the lock is held by the calling process
+func lockCacheDir(cacheDir string) (*cacheLock, error) {
+	f, err := os.OpenFile(filepath.Join(cacheDir, lockFileName), os.O_RDWR|os.O_CREATE, c.CacheDirPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	// try without blocking first, so we can tell the user why we are waiting
+	if err = tryLockFile(f); err != nil {
+		log.Println("[INFO] simplecert: cacheDir is locked by another process, waiting for it to finish...")
+		if err = lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &cacheLock{f: f}, nil
+}
+
+// unlock releases the lock and closes the lockfile handle
+func (l *cacheLock) unlock() {
+	if err := unlockFile(l.f); err != nil {
+		log.Println("[ERROR] simplecert: failed to release cacheDir lock: ", err)
+	}
+	l.f.Close()
+}
@@ -0,0 +1,94 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// size of the logfile at which it is rotated, if no MaxLogSize has been configured
+	defaultMaxLogSize = 10 << 20
+
+	// number of rotated logfiles that are kept, named simplecert.log.1, simplecert.log.2...
+	numRotatedLogs = 2
+)
+
+// logWriter writes to the logfile and rotates it once it exceeds the maximum size.
+// The logfile is rotated by copying and truncating it, so the file handle stays valid
+// and can be closed by the CertReloader.
+type logWriter struct {
+	sync.Mutex
+	file    *os.File
+	size    int64
+	maxSize int64
+}
+
+// newLogWriter returns a logWriter for the opened logfile, or the file itself if rotation is disabled
+func newLogWriter(file *os.File) io.Writer {
+	maxSize := c.MaxLogSize
+	if maxSize == 0 {
+		maxSize = defaultMaxLogSize
+	}
+	if maxSize < 0 {
+		return file
+	}
+
+	w := &logWriter{
+		file:    file,
+		maxSize: maxSize,
+	}
+	if info, err := file.Stat(); err == nil {
+		w.size = info.Size()
+	}
+
+	return w
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		err := w.rotate()
+		if err != nil {
+			// keep logging into the current file
+			fmt.Fprintln(os.Stderr, "[ERROR] simplecert: failed to rotate logfile: ", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated logfiles, copies the logfile to <logfile>.1 and truncates it
+func (w *logWriter) rotate() error {
+	name := w.file.Name()
+	for i := numRotatedLogs - 1; i > 0; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", name, i), fmt.Sprintf("%s.%d", name, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err := copyFile(name, name+".1")
+	if err != nil {
+		return err
+	}
+
+	// the file has been opened with O_APPEND, so the next write starts at the beginning
+	err = w.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	w.size = 0
+
+	return nil
+}
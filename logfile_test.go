@@ -0,0 +1,54 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogRotation(t *testing.T) {
+	setConfig(&Config{MaxLogSize: 10})
+	defer setConfig(nil)
+
+	path := filepath.Join(t.TempDir(), logFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := newLogWriter(f)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s contains %q, want %q", name, data, want)
+		}
+	}
+
+	// only two rotated files are kept
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected no third rotated logfile")
+	}
+	if matches, _ := filepath.Glob(path + "*"); len(matches) != 3 {
+		t.Errorf("unexpected logfiles: %s", strings.Join(matches, ", "))
+	}
+}
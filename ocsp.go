@@ -0,0 +1,130 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// retry interval if fetching an OCSP response failed
+	ocspRetryInterval = 10 * time.Minute
+
+	// never refresh more often than this, even if the responder sends very short lived responses
+	ocspMinRefreshInterval = time.Minute
+
+	// OCSP responses larger than this are rejected
+	ocspMaxResponseSize = 1 << 20
+)
+
+var ocspClient = &http.Client{Timeout: 30 * time.Second}
+
+// stapleOCSP fetches an OCSP response for the currently loaded cert and staples it.
+// It schedules the next refresh at half of the response validity.
+// Failures are logged, the cert will be served without a staple in that case.
+func (reloader *CertReloader) stapleOCSP() {
+	reloader.RLock()
+	cert := reloader.cert
+	reloader.RUnlock()
+
+	next := ocspRetryInterval
+
+	raw, resp, err := fetchOCSP(cert)
+	if err != nil {
+		log.Println("[WARNING] simplecert: failed to fetch OCSP response, serving cert without staple: ", err)
+	} else {
+		// copy the certificate, it might be in use by a running handshake
+		stapled := *cert
+		stapled.OCSPStaple = raw
+
+		reloader.Lock()
+		// the cert might have been reloaded in the meantime
+		if reloader.cert == cert {
+			reloader.cert = &stapled
+		}
+		reloader.Unlock()
+
+		if !resp.NextUpdate.IsZero() {
+			next = resp.NextUpdate.Sub(resp.ThisUpdate) / 2
+			if next < ocspMinRefreshInterval {
+				next = ocspMinRefreshInterval
+			}
+		}
+		log.Println("[INFO] simplecert: stapled OCSP response, next update in", next)
+	}
+
+	reloader.Lock()
+	if reloader.ocspTimer != nil {
+		reloader.ocspTimer.Stop()
+	}
+	// do not reschedule once the reloader has been closed
+	if !reloader.closed() {
+		reloader.ocspTimer = time.AfterFunc(next, reloader.stapleOCSP)
+	}
+	reloader.Unlock()
+}
+
+// fetchOCSP queries the OCSP responder of the leaf certificate
+// the issuer certificate must be part of the chain
+func fetchOCSP(cert *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, nil, errors.New("no issuer certificate in chain")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, errors.New("certificate has no OCSP responder URL")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpResp, err := ocspClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d", httpResp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("OCSP status of certificate is not good: %d", resp.Status)
+	}
+
+	return raw, resp, nil
+}
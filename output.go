@@ -0,0 +1,120 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"encoding/pem"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// artifacts that can be configured in Config.OutputFiles
+const (
+	OutputFullChain = "fullchain"
+	OutputPrivKey   = "privkey"
+	OutputChain     = "chain"
+	OutputCert      = "cert"
+)
+
+// combinedPEMFileName is written to the cacheDir if Config.WriteCombinedPEM is set
+const combinedPEMFileName = "fullchain+key.pem"
+
+var supportedOutputFiles = map[string]bool{
+	OutputFullChain: true,
+	OutputPrivKey:   true,
+	OutputChain:     true,
+	OutputCert:      true,
+}
+
+// writeOutputFiles writes the artifacts configured in OutputFiles to their target paths
+func writeOutputFiles(cert *certificate.Resource) error {
+	if len(c.OutputFiles) == 0 {
+		return nil
+	}
+
+	leaf, chain := splitBundle(cert.Certificate)
+
+	// fall back to the issuer from the resource if the cert is not bundled
+	if len(chain) == 0 {
+		chain = cert.IssuerCertificate
+	}
+
+	for artifact, path := range c.OutputFiles {
+		var data []byte
+		switch artifact {
+		case OutputFullChain:
+			data = append(append([]byte{}, leaf...), chain...)
+		case OutputCert:
+			data = leaf
+		case OutputChain:
+			data = chain
+		case OutputPrivKey:
+			data = cert.PrivateKey
+		default:
+			return fmt.Errorf("unsupported output file: %s", artifact)
+		}
+
+		if len(data) == 0 {
+			return fmt.Errorf("no data for output file %s", artifact)
+		}
+
+		err := writeFileAtomic(path, data, c.CacheDirPerm)
+		if err != nil {
+			return fmt.Errorf("failed to write %s to %s: %s", artifact, path, err)
+		}
+	}
+
+	return nil
+}
+
+// writeCombinedPEM writes the full chain followed by the private key into the cacheDir,
+// as expected by e.g. HAProxy
+func writeCombinedPEM(cert *certificate.Resource, cacheDir string) error {
+	if !c.WriteCombinedPEM {
+		return nil
+	}
+
+	// certificates obtained for a CSR do not come with a private key
+	if len(cert.PrivateKey) == 0 {
+		log.Println("[WARNING] simplecert: no private key available, not writing", combinedPEMFileName)
+		return nil
+	}
+
+	data := append([]byte{}, cert.Certificate...)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, cert.PrivateKey...)
+
+	err := writeFileAtomic(filepath.Join(cacheDir, combinedPEMFileName), data, c.CacheDirPerm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", combinedPEMFileName, err)
+	}
+
+	return nil
+}
+
+// splitBundle splits a PEM encoded certificate bundle into the leaf and the remaining chain
+func splitBundle(bundle []byte) (leaf []byte, chain []byte) {
+	block, rest := pem.Decode(bundle)
+	if block == nil {
+		return bundle, nil
+	}
+
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			break
+		}
+		chain = append(chain, pem.EncodeToMemory(b)...)
+	}
+
+	return pem.EncodeToMemory(block), chain
+}
@@ -0,0 +1,74 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// name of the PKCS#12 archive written into the cacheDir if WritePKCS12 is set
+const pkcs12FileName = "cert.p12"
+
+var errNoCertLoaded = errors.New("simplecert: no certificate loaded")
+
+// ExportPKCS12 bundles the currently loaded certificate, its chain and the private key
+// into a PKCS#12 archive (.p12 / .pfx) protected by password.
+// The archive always reflects the cert that is currently served, including renewals that have been reloaded.
+func (reloader *CertReloader) ExportPKCS12(password string) ([]byte, error) {
+	reloader.RLock()
+	cert := reloader.cert
+	reloader.RUnlock()
+
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, errNoCertLoaded
+	}
+
+	return encodePKCS12(cert, password)
+}
+
+// encodePKCS12 encodes a tls.Certificate as PKCS#12 archive
+func encodePKCS12(cert *tls.Certificate, password string) ([]byte, error) {
+	if cert.PrivateKey == nil {
+		return nil, errors.New("simplecert: certificate has no private key")
+	}
+
+	var chain []*x509.Certificate
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, c)
+	}
+
+	return pkcs12.Modern.Encode(cert.PrivateKey, chain[0], chain[1:], password)
+}
+
+// writePKCS12 writes the PEM encoded cert and key as PKCS#12 archive to path
+func writePKCS12(certPEM, keyPEM []byte, path string) error {
+	// certs obtained for a CSR have no private key
+	if len(keyPEM) == 0 {
+		log.Println("[WARNING] simplecert: not writing PKCS12 archive, the certificate has no private key")
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodePKCS12(&cert, c.PKCS12Password)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, c.CacheDirPerm)
+}
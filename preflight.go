@@ -0,0 +1,162 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// timeout for a single domain lookup during the preflight check
+const preflightLookupTimeout = 10 * time.Second
+
+// CheckDomainsResolve makes sure that every domain in cfg resolves to an address of this machine.
+// This is required for the HTTP and TLS challenges to succeed, and checking it upfront
+// gives a fast and actionable error, instead of a failed challenge after a long timeout.
+// The lookups use the DNSServers of cfg, if configured.
+// The addresses are compared to the PublicIPs of cfg, or the addresses of the interfaces if there are none.
+// Wildcard domains are skipped, since they can only be validated with the DNS challenge.
+func CheckDomainsResolve(cfg *Config) error {
+	localIPs := cfg.PublicIPs
+	if len(localIPs) == 0 {
+		var err error
+		localIPs, err = localAddresses()
+		if err != nil {
+			return fmt.Errorf("simplecert: failed to get local addresses: %s", err)
+		}
+	}
+
+	resolver := newResolver(cfg.DNSServers)
+
+	for _, d := range cfg.asciiDomains() {
+		if strings.HasPrefix(d, "*.") {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), preflightLookupTimeout)
+		addrs, err := resolver.LookupIPAddr(ctx, d)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("simplecert: failed to resolve %s: %s", d, err)
+		}
+
+		if !containsAnyIP(localIPs, addrs) {
+			return fmt.Errorf("simplecert: %s resolves to %s but this host is %s", d, joinIPAddrs(addrs), joinIPs(localIPs))
+		}
+
+		log.Println("[INFO] simplecert: preflight check:", d, "resolves to this host")
+	}
+
+	return nil
+}
+
+// newResolver returns a resolver that queries the supplied nameservers,
+// or the system resolver if there are none
+func newResolver(nameservers []string) *net.Resolver {
+	if len(nameservers) == 0 {
+		return net.DefaultResolver
+	}
+
+	var (
+		servers = dns01.ParseNameservers(nameservers)
+		dialer  net.Dialer
+	)
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// try the configured nameservers in order
+			var err error
+			for _, s := range servers {
+				var conn net.Conn
+				conn, err = dialer.DialContext(ctx, network, s)
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+	}
+}
+
+// localAddresses returns all IP addresses assigned to the interfaces of this machine
+func localAddresses() ([]net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			ips = append(ips, ipNet.IP)
+		}
+	}
+
+	return ips, nil
+}
+
+func containsAnyIP(ips []net.IP, addrs []net.IPAddr) bool {
+	for _, a := range addrs {
+		for _, ip := range ips {
+			if ip.Equal(a.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func joinIPAddrs(addrs []net.IPAddr) string {
+	s := make([]string, len(addrs))
+	for i, a := range addrs {
+		s[i] = a.IP.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// checkChallengePorts makes sure the addresses of the standalone challenge servers can be bound,
+// so a port held by another process is reported before contacting the CA, instead of failing inside lego.
+func checkChallengePorts(cfg *Config) error {
+	network := cfg.ChallengeNetwork
+	if network == "" {
+		network = "tcp"
+	}
+
+	for _, server := range []struct {
+		name string
+		addr string
+	}{
+		{"HTTP", cfg.HTTPAddress},
+		{"TLS", cfg.TLSAddress},
+	} {
+		if server.addr == "" {
+			continue
+		}
+
+		l, err := net.Listen(network, server.addr)
+		if err != nil {
+			return fmt.Errorf("simplecert: cannot bind %s for %s challenge: %w", server.addr, server.name, err)
+		}
+		l.Close()
+	}
+
+	return nil
+}
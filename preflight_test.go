@@ -0,0 +1,52 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCheckDomainsResolvePublicIPs(t *testing.T) {
+	cfg := &Config{
+		Domains:   []string{"localhost", "*.example.com"},
+		PublicIPs: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if err := CheckDomainsResolve(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.PublicIPs = []net.IP{net.ParseIP("192.0.2.1")}
+	err := CheckDomainsResolve(cfg)
+	if err == nil {
+		t.Fatal("expected an error for a domain not resolving to the public IP")
+	}
+	for _, s := range []string{"localhost", "127.0.0.1", "192.0.2.1"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("expected the error to name %s, got: %v", s, err)
+		}
+	}
+}
+
+func TestCheckChallengePorts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	cfg := &Config{HTTPAddress: addr}
+	err = checkChallengePorts(cfg)
+	if err == nil || !strings.Contains(err.Error(), "cannot bind "+addr+" for HTTP challenge") {
+		t.Fatalf("expected an error for the port in use, got %v", err)
+	}
+
+	l.Close()
+	if err := checkChallengePorts(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,41 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"log"
+	"sort"
+)
+
+// checkProfile logs the profiles advertised by the CA
+// and warns if the configured profile is not among them
+func checkProfile() {
+	meta, err := fetchDirectoryMeta(c)
+	if err != nil {
+		log.Println("[WARNING] simplecert: failed to fetch profiles from the ACME directory: ", err)
+		return
+	}
+
+	profiles := meta.Profiles
+	if len(profiles) == 0 {
+		log.Println("[WARNING] simplecert: the CA does not advertise any profiles, it might reject profile", c.Profile)
+		return
+	}
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		log.Printf("[INFO] simplecert: CA advertises profile %q: %s", name, profiles[name])
+	}
+
+	if _, ok := profiles[c.Profile]; !ok {
+		log.Printf("[WARNING] simplecert: profile %q is not advertised by the CA", c.Profile)
+	}
+}
@@ -0,0 +1,77 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+// problem type of the CA for rate limited requests
+const rateLimitedProblem = "urn:ietf:params:acme:error:rateLimited"
+
+// matches the retry time in the problem detail of Let's Encrypt,
+// e.g. "retry after 2024-01-02 03:04:05 UTC" or "retry after 2024-01-02T03:04:05Z"
+var retryAfterRegex = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?: UTC|Z))`)
+
+// ErrRateLimited is returned when the CA rejected a request due to a rate limit.
+// Retrying before RetryAfter will fail again and count against the rate limit.
+type ErrRateLimited struct {
+	// RetryAfter is the earliest time to retry, zero if the CA did not provide it
+	RetryAfter time.Time
+
+	// Err is the problem reported by the CA
+	Err error
+}
+
+func (e *ErrRateLimited) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("simplecert: rate limited by the CA: %s", e.Err)
+	}
+	return fmt.Sprintf("simplecert: rate limited by the CA until %s: %s", e.RetryAfter.Format(time.RFC3339), e.Err)
+}
+
+func (e *ErrRateLimited) Unwrap() error {
+	return e.Err
+}
+
+// asRateLimitError wraps err into an ErrRateLimited if the CA rejected the request due to a rate limit
+// all other errors are returned unchanged
+func asRateLimitError(err error) error {
+	var problem *acme.ProblemDetails
+	if !errors.As(err, &problem) {
+		return err
+	}
+	if problem.Type != rateLimitedProblem && problem.HTTPStatus != http.StatusTooManyRequests {
+		return err
+	}
+
+	return &ErrRateLimited{
+		RetryAfter: parseRetryAfter(problem.Detail),
+		Err:        err,
+	}
+}
+
+// parseRetryAfter extracts the retry time from the problem detail, zero if there is none
+func parseRetryAfter(detail string) time.Time {
+	m := retryAfterRegex.FindStringSubmatch(detail)
+	if m == nil {
+		return time.Time{}
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04:05 MST", time.RFC3339} {
+		if t, err := time.Parse(layout, m[1]); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,180 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+func TestAsRateLimitError(t *testing.T) {
+	retryAfter := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		err            error
+		wantLimited    bool
+		wantRetryAfter time.Time
+	}{
+		{
+			"problem type with retry after",
+			&acme.ProblemDetails{Type: rateLimitedProblem, HTTPStatus: http.StatusTooManyRequests, Detail: "too many certificates already issued, retry after 2024-01-02 03:04:05 UTC"},
+			true, retryAfter,
+		},
+		{
+			"problem type with RFC 3339 retry after",
+			&acme.ProblemDetails{Type: rateLimitedProblem, Detail: "too many new orders, retry after 2024-01-02T03:04:05Z: see https://letsencrypt.org/docs/rate-limits/"},
+			true, retryAfter,
+		},
+		{
+			"status 429 with retry after",
+			&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:malformed", HTTPStatus: http.StatusTooManyRequests, Detail: "retry after 2024-01-02 03:04:05 UTC"},
+			true, retryAfter,
+		},
+		{
+			"problem type without retry after",
+			&acme.ProblemDetails{Type: rateLimitedProblem, Detail: "too many failed authorizations recently"},
+			true, time.Time{},
+		},
+		{
+			"status 429 without retry after",
+			&acme.ProblemDetails{HTTPStatus: http.StatusTooManyRequests, Detail: "slow down"},
+			true, time.Time{},
+		},
+		{
+			"unparsable retry after",
+			&acme.ProblemDetails{Type: rateLimitedProblem, Detail: "retry after tomorrow"},
+			true, time.Time{},
+		},
+		{
+			"wrapped problem",
+			fmt.Errorf("simplecert: failed to renew: %w", &acme.ProblemDetails{Type: rateLimitedProblem, Detail: "retry after 2024-01-02 03:04:05 UTC"}),
+			true, retryAfter,
+		},
+		{
+			"other problem",
+			&acme.ProblemDetails{Type: "urn:ietf:params:acme:error:unauthorized", HTTPStatus: http.StatusForbidden, Detail: "retry after 2024-01-02 03:04:05 UTC"},
+			false, time.Time{},
+		},
+		{
+			"no problem",
+			errors.New("connection refused"),
+			false, time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := asRateLimitError(tt.err)
+
+			var rateLimited *ErrRateLimited
+			if !errors.As(err, &rateLimited) {
+				if tt.wantLimited {
+					t.Fatalf("expected ErrRateLimited, got %v", err)
+				}
+				if err != tt.err {
+					t.Fatalf("expected the error to be returned unchanged, got %v", err)
+				}
+				return
+			}
+			if !tt.wantLimited {
+				t.Fatalf("unexpected ErrRateLimited %v", err)
+			}
+			if !rateLimited.RetryAfter.Equal(tt.wantRetryAfter) {
+				t.Errorf("RetryAfter = %s, want %s", rateLimited.RetryAfter, tt.wantRetryAfter)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected ErrRateLimited to wrap %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestRenewalRoutineRateLimited(t *testing.T) {
+	retryAfter := time.Now().UTC().Add(3 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name     string
+		detail   string
+		wantNext time.Time
+	}{
+		{"retry after", "too many certificates already issued, retry after " + retryAfter.Format("2006-01-02 15:04:05 MST"), retryAfter},
+		// without a retry after, the next attempt is made in the CheckInterval
+		{"fallback", "too many certificates already issued", time.Now().Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeClient{t: t, renewErr: &acme.ProblemDetails{
+				Type:       rateLimitedProblem,
+				HTTPStatus: http.StatusTooManyRequests,
+				Detail:     tt.detail,
+			}}
+			newClient := newACMEClient
+			newACMEClient = func() (acmeClient, error) { return fake, nil }
+			defer func() { newACMEClient = newClient }()
+
+			failed := make(chan error, 1)
+			cfg := &Config{
+				CacheDir:                 t.TempDir(),
+				CacheDirPerm:             0700,
+				Domains:                  []string{"example.com"},
+				RenewBefore:              30 * 24,
+				CheckInterval:            time.Hour,
+				FailedToRenewCertificate: func(err error) { failed <- err },
+			}
+
+			cert := testCertResource(t, 10*24*time.Hour)
+			restore := useConfig(cfg)
+			err := saveCertToDisk(cert, cfg.CacheDir)
+			restore()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reloader := newCertReloader()
+			reloader.cfg = cfg
+			defer reloader.Close()
+			if err = reloader.loadFrom(filepath.Join(cfg.CacheDir, certFileName), filepath.Join(cfg.CacheDir, keyFileName)); err != nil {
+				t.Fatal(err)
+			}
+
+			go renewalRoutine(cert, reloader, 0, 0)
+
+			select {
+			case err := <-failed:
+				var rateLimited *ErrRateLimited
+				if !errors.As(err, &rateLimited) {
+					t.Fatalf("expected ErrRateLimited, got %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the renewal has not failed")
+			}
+
+			// the next attempt is scheduled once the handler has returned
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				next := reloader.NextRenewal()
+				if d := next.Sub(tt.wantNext); d > -time.Minute && d < time.Minute {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("next renewal at %s, want %s", next, tt.wantNext)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if fake.renewed != 1 {
+				t.Errorf("renewed %d times, want 1", fake.renewed)
+			}
+		})
+	}
+}
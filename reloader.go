@@ -6,13 +6,20 @@
 package simplecert
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"sync"
 	"syscall"
+	"time"
 )
 
 /*
@@ -25,35 +32,132 @@ type CertReloader struct {
 	cert     *tls.Certificate
 	certPath string
 	keyPath  string
+
+	// refreshes the OCSP staple, if enabled
+	ocspTimer *time.Timer
+
+	// time of the next renewal check, set by the renewal routine
+	nextRenewal time.Time
+
+	// renewal time suggested by the CA via ARI that lies before the next regular check, zero if there is none
+	ariRenewAt time.Time
+
+	// backup dir of the last renewal, empty if there is none
+	backupDir string
+
+	// config the reloader has been started with
+	cfg *Config
+
+	// created by NewReloaderOnly, there are no backups to roll back to
+	reloadOnly bool
+
+	logFile    *os.File
+	sigChan    chan os.Signal
+	reloadChan chan struct{}
+
+	// closed to stop the renewal routine and the signal handler
+	stop      chan struct{}
+	closeOnce sync.Once
+	logOnce   sync.Once
+}
+
+// startedReloaders holds the reloaders started in this process by the path of their cert,
+// so RollbackCert can reload the restored cert without signaling a process that might not handle the signal
+var startedReloaders sync.Map
+
+// startedReloader returns the reloader started in this process for the cert at certPath, or nil if there is none
+func startedReloader(certPath string) *CertReloader {
+	if r, ok := startedReloaders.Load(certPath); ok {
+		return r.(*CertReloader)
+	}
+	return nil
 }
 
 // NewCertReloader returns a new CertReloader instance
 // the optional cleanup func will be called when a syscall.SIGINT, syscall.SIGABRT is received
 func NewCertReloader(certPath, keyPath string, logFile *os.File, cleanup func()) (*CertReloader, error) {
-	// init reloader
-	reloader := &CertReloader{
-		certPath: certPath,
-		keyPath:  keyPath,
+	reloader := newCertReloader()
+	err := reloader.start(certPath, keyPath, logFile, cleanup)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// newCertReloader returns a reloader without a cert
+func newCertReloader() *CertReloader {
+	return &CertReloader{
+		sigChan:    make(chan os.Signal, 1),
+		reloadChan: make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+// start loads the cert and key from disk, replacing the current cert,
+// and kicks off the signal handler, the OCSP refresh and the file watcher
+func (reloader *CertReloader) start(certPath, keyPath string, logFile *os.File, cleanup func()) error {
+	// files replaced while loading are detected by the watcher
+	certVersion, keyVersion := statFile(certPath), statFile(keyPath)
+
+	cfg := reloader.cfg
+	if cfg == nil {
+		cfg = c
 	}
 
 	// Load keypair
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	cert, err := loadKeyPair(cfg, certPath, keyPath)
 	if err != nil {
-		return nil, err
+		return err
 	}
+
+	reloader.Lock()
 	reloader.cert = &cert
+	reloader.certPath = certPath
+	reloader.keyPath = keyPath
+	reloader.logFile = logFile
+	reloader.cfg = cfg
+	reloader.Unlock()
+
+	// a fallback reloader might have been closed already
+	if reloader.closed() {
+		return nil
+	}
+	startedReloaders.Store(certPath, reloader)
+
+	if cfg.EnableOCSPStapling {
+		reloader.stapleOCSP()
+	}
+
+	// reload the cert when the files are replaced on disk
+	if cfg.WatchCertFiles {
+		go reloader.watchCertFiles(certVersion, keyVersion)
+	}
 
 	// kickoff routine for handling singals
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGABRT}
+	if !cfg.DisableReloadSignal {
+		signals = append(signals, cfg.reloadSignal())
+	}
+	signal.Notify(reloader.sigChan, signals...)
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGABRT)
-		for sig := range sigChan {
-			if sig == syscall.SIGHUP {
-				log.Printf("Received SIGHUP, reloading TLS certificate and key from %q and %q", certPath, keyPath)
+		for {
+			var sig os.Signal
+			select {
+			case <-reloader.stop:
+				return
+			case <-reloader.reloadChan:
+				log.Printf("Reload requested, reloading TLS certificate and key from %q and %q", certPath, keyPath)
+				reloader.reload()
+				continue
+			case sig = <-reloader.sigChan:
+			}
+
+			if !cfg.DisableReloadSignal && sig == cfg.reloadSignal() {
+				log.Printf("Received %s, reloading TLS certificate and key from %q and %q", sig, certPath, keyPath)
 				reloader.reload()
 			} else {
 				// cleanup
-				err := logFile.Close()
+				err := reloader.closeLogFile()
 				if err != nil {
 					log.Println("[FATAL] simplecert: failed to close logfile handle: ", err)
 				}
@@ -72,11 +176,16 @@ func NewCertReloader(certPath, keyPath string, logFile *os.File, cleanup func())
 		}
 	}()
 
-	return reloader, nil
+	return nil
 }
 
 func (reloader *CertReloader) maybeReload() error {
-	newCert, err := tls.LoadX509KeyPair(reloader.certPath, reloader.keyPath)
+	certPath, keyPath := reloader.paths()
+
+	// do not read the files while a new cert is being written
+	diskMu.Lock()
+	newCert, err := loadKeyPair(reloader.config(), certPath, keyPath)
+	diskMu.Unlock()
 	if err != nil {
 		return err
 	}
@@ -86,6 +195,23 @@ func (reloader *CertReloader) maybeReload() error {
 	return nil
 }
 
+// paths returns the cert and key path, empty for a fallback reloader until Init completes
+func (reloader *CertReloader) paths() (certPath, keyPath string) {
+	reloader.RLock()
+	defer reloader.RUnlock()
+	return reloader.certPath, reloader.keyPath
+}
+
+// config returns the config the reloader has been started with, or the global config before
+func (reloader *CertReloader) config() *Config {
+	reloader.RLock()
+	defer reloader.RUnlock()
+	if reloader.cfg != nil {
+		return reloader.cfg
+	}
+	return current.Load()
+}
+
 // GetCertificateFunc is needed for hot reload
 func (reloader *CertReloader) GetCertificateFunc() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
@@ -95,35 +221,260 @@ func (reloader *CertReloader) GetCertificateFunc() func(*tls.ClientHelloInfo) (*
 	}
 }
 
+// NotAfter returns the expiry time of the current cert
+func (reloader *CertReloader) NotAfter() time.Time {
+	reloader.RLock()
+	defer reloader.RUnlock()
+
+	leaf, err := x509.ParseCertificate(reloader.cert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	return leaf.NotAfter
+}
+
+// CertPEM returns the PEM encoded chain of the currently loaded cert, starting with the leaf.
+// It reflects what is actually served, even if the files on disk have changed since the last reload.
+func (reloader *CertReloader) CertPEM() ([]byte, error) {
+	reloader.RLock()
+	defer reloader.RUnlock()
+
+	if reloader.cert == nil || len(reloader.cert.Certificate) == 0 {
+		return nil, errNoCertLoaded
+	}
+
+	var buf bytes.Buffer
+	for _, der := range reloader.cert.Certificate {
+		err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// KeyPEM returns the private key of the currently loaded cert, PEM encoded in PKCS#8 format
+func (reloader *CertReloader) KeyPEM() ([]byte, error) {
+	reloader.RLock()
+	defer reloader.RUnlock()
+
+	if reloader.cert == nil || reloader.cert.PrivateKey == nil {
+		return nil, errNoCertLoaded
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(reloader.cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// NextRenewal returns the time at which the cert will be checked for renewal next,
+// or the zero time if there is no renewal routine, e.g. in local mode
+func (reloader *CertReloader) NextRenewal() time.Time {
+	reloader.RLock()
+	defer reloader.RUnlock()
+	return reloader.nextRenewal
+}
+
+// setNextRenewal records the time of the next renewal check
+func (reloader *CertReloader) setNextRenewal(wait time.Duration) {
+	reloader.Lock()
+	defer reloader.Unlock()
+	reloader.nextRenewal = time.Now().Add(wait)
+}
+
+// setARIRenewAt records the renewal time suggested via ARI for the next check
+func (reloader *CertReloader) setARIRenewAt(t time.Time) {
+	reloader.Lock()
+	defer reloader.Unlock()
+	reloader.ariRenewAt = t
+}
+
+// takeARIRenewAt returns and clears the renewal time suggested via ARI
+func (reloader *CertReloader) takeARIRenewAt() time.Time {
+	reloader.Lock()
+	defer reloader.Unlock()
+	t := reloader.ariRenewAt
+	reloader.ariRenewAt = time.Time{}
+	return t
+}
+
+// setBackupDir records the backup dir created for the last renewal of the cert
+func (reloader *CertReloader) setBackupDir(dir string) {
+	reloader.Lock()
+	defer reloader.Unlock()
+	reloader.backupDir = dir
+}
+
+// lastBackupDir returns the backup dir created for the last renewal of the cert, empty if there is none
+func (reloader *CertReloader) lastBackupDir() string {
+	reloader.RLock()
+	defer reloader.RUnlock()
+	return reloader.backupDir
+}
+
+// Close stops the renewal routine, the signal handler and the OCSP refresh
+// and closes the logfile. It is safe to call Close multiple times.
+func (reloader *CertReloader) Close() error {
+	var err error
+	reloader.closeOnce.Do(func() {
+		signal.Stop(reloader.sigChan)
+		close(reloader.stop)
+
+		reloader.Lock()
+		startedReloaders.CompareAndDelete(reloader.certPath, reloader)
+		if reloader.ocspTimer != nil {
+			reloader.ocspTimer.Stop()
+		}
+		reloader.Unlock()
+
+		err = reloader.closeLogFile()
+	})
+	return err
+}
+
+// reloadSignal returns the configured reload signal or SIGHUP if none is set
+func (c *Config) reloadSignal() os.Signal {
+	if c.ReloadSignal != nil {
+		return c.ReloadSignal
+	}
+	return syscall.SIGHUP
+}
+
+// closeLogFile resets the log output to stdout and closes the logfile handle
+func (reloader *CertReloader) closeLogFile() error {
+	var err error
+	reloader.logOnce.Do(func() {
+		if reloader.logFile == nil {
+			return
+		}
+		log.SetOutput(os.Stdout)
+		err = reloader.logFile.Close()
+	})
+	return err
+}
+
+// closed reports whether Close has been called
+func (reloader *CertReloader) closed() bool {
+	select {
+	case <-reloader.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReloadChan returns a channel that triggers reloading the cert from disk when a value is sent on it.
+// This works without signals and can be used on all platforms.
+// The channel is buffered, a send only blocks while another reload request is pending.
+func (reloader *CertReloader) ReloadChan() chan<- struct{} {
+	return reloader.reloadChan
+}
+
 // ReloadNow will force reloading the cert from disk
 func (reloader *CertReloader) ReloadNow() {
 	reloader.reload()
 }
 
+// ReloadFrom loads the cert and key from the given paths and swaps them in atomically.
+// If the pair can not be loaded, the current cert is kept and the error is returned.
+// Reloads triggered by a signal or a renewal still read the paths the reloader was created with.
+func (reloader *CertReloader) ReloadFrom(certPath, keyPath string) error {
+	err := reloader.loadFrom(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	// fetch a staple for the new cert
+	if reloader.config().EnableOCSPStapling {
+		reloader.stapleOCSP()
+	}
+
+	return nil
+}
+
+// loadFrom loads the cert and key from the given paths and swaps them in, without fetching an OCSP staple
+func (reloader *CertReloader) loadFrom(certPath, keyPath string) error {
+	newCert, err := loadKeyPair(reloader.config(), certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	reloader.Lock()
+	reloader.cert = &newCert
+	reloader.Unlock()
+
+	return nil
+}
+
 func (reloader *CertReloader) reload() {
-	if err := reloader.maybeReload(); err != nil {
-		// there was an error reloading the certificate
-		// rollback files from backup dir
-		log.Printf("[INFO] simplecert: Keeping old TLS certificate because the new one could not be loaded: %v", err)
-
-		// restore private key
-		backupPrivKey := filepath.Join(c.CacheDir, "backup-"+backupDate, keyFileName)
-		err = os.Rename(backupPrivKey, filepath.Join(c.CacheDir, keyFileName))
-		if err != nil {
-			log.Fatal("[FATAL] simplecert: failed to move key into backup dir: ", err)
-		}
+	// a fallback reloader has nothing to reload until Init completes
+	certPath, _ := reloader.paths()
+	if certPath == "" {
+		log.Println("[INFO] simplecert: no cert to reload yet, keeping the fallback cert")
+		return
+	}
+	cfg := reloader.config()
 
-		// restore certificate
-		backupCert := filepath.Join(c.CacheDir, "backup-"+backupDate, certFileName)
-		err = os.Rename(backupCert, filepath.Join(c.CacheDir, certFileName))
-		if err != nil {
-			log.Fatal("[FATAL] simplecert: failed to move cert into backup dir: ", err)
+	err := reloader.maybeReload()
+	if err == nil {
+		logEvent(slog.LevelInfo, "reloaded cert", "cert", certPath, "not_after", reloader.NotAfter())
+
+		// fetch a staple for the new cert
+		if cfg.EnableOCSPStapling {
+			reloader.stapleOCSP()
 		}
+		return
+	}
+
+	// there was an error reloading the certificate
+	reportError("reload", err)
+
+	// rollback files from backup dir
+	log.Printf("[INFO] simplecert: Keeping old TLS certificate because the new one could not be loaded: %v", err)
+	if reloader.reloadOnly {
+		return
+	}
+
+	// the reload might have been triggered by a watcher or the reload channel,
+	// without a renewal that created a backup
+	err = rollbackBackup(cfg, filepath.Dir(certPath), reloader.lastBackupDir())
+	if err != nil {
+		log.Println("[ERROR] simplecert: failed to restore the cert from the backup dir: ", err)
+		reportError("reload", err)
+	}
+}
+
+// rollbackBackup moves the cert and key from the backup dir of the last renewal back into the cacheDir
+func rollbackBackup(cfg *Config, cacheDir, backupDir string) error {
+	diskMu.Lock()
+	defer diskMu.Unlock()
 
-		// remove backup directory
-		err = os.Remove(filepath.Join(c.CacheDir, "backup-"+backupDate))
+	if backupDir == "" {
+		return errors.New("no backup has been created")
+	}
+
+	// restore private key
+	// there is none if the cert has been obtained for a CSR
+	backupPrivKey := filepath.Join(backupDir, cfg.keyFile())
+	if _, err := os.Stat(backupPrivKey); err == nil {
+		err = os.Rename(backupPrivKey, filepath.Join(cacheDir, cfg.keyFile()))
 		if err != nil {
-			log.Fatal("[FATAL] simplecert: failed to remove backup dir: ", err)
+			return fmt.Errorf("failed to move key out of the backup dir: %w", err)
 		}
 	}
+
+	// restore certificate
+	err := os.Rename(filepath.Join(backupDir, cfg.certFile()), filepath.Join(cacheDir, cfg.certFile()))
+	if err != nil {
+		return fmt.Errorf("failed to move cert out of the backup dir: %w", err)
+	}
+
+	// remove backup directory
+	err = os.Remove(backupDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove backup dir: %w", err)
+	}
+	return nil
 }
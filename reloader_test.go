@@ -0,0 +1,45 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadWithoutBackup(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certPath = filepath.Join(dir, certFileName)
+		keyPath  = filepath.Join(dir, keyFileName)
+		cert     = testCertResource(t, time.Hour)
+	)
+	if err := os.WriteFile(certPath, cert.Certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, cert.PrivateKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloader := newCertReloader()
+	reloader.cfg = &Config{CacheDir: dir, DisableReloadSignal: true}
+	if err := reloader.start(certPath, keyPath, nil, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// a broken cert without a backup to restore is logged instead of exiting
+	loaded := reloader.NotAfter()
+	if err := os.WriteFile(certPath, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader.ReloadNow()
+	if !reloader.NotAfter().Equal(loaded) {
+		t.Fatal("expected the current cert to be kept")
+	}
+}
@@ -0,0 +1,94 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"time"
+)
+
+// reloaderOptions are set by the Options passed to NewReloaderOnly
+type reloaderOptions struct {
+	cfg     Config
+	cleanup func()
+}
+
+// Option configures a CertReloader created by NewReloaderOnly
+type Option func(*reloaderOptions)
+
+// WithWatchInterval sets the interval in which the cert files are checked for changes
+func WithWatchInterval(interval time.Duration) Option {
+	return func(o *reloaderOptions) {
+		o.cfg.WatchInterval = interval
+	}
+}
+
+// WithoutWatch disables watching the cert files, reload with a signal, ReloadChan or ReloadNow instead
+func WithoutWatch() Option {
+	return func(o *reloaderOptions) {
+		o.cfg.WatchCertFiles = false
+	}
+}
+
+// WithReloadSignal sets the signal that triggers a reload, defaults to syscall.SIGHUP
+func WithReloadSignal(sig os.Signal) Option {
+	return func(o *reloaderOptions) {
+		o.cfg.ReloadSignal = sig
+	}
+}
+
+// WithoutReloadSignal disables reloading on a signal
+func WithoutReloadSignal() Option {
+	return func(o *reloaderOptions) {
+		o.cfg.DisableReloadSignal = true
+	}
+}
+
+// WithOCSPStapling staples an OCSP response to the cert and refreshes it periodically
+func WithOCSPStapling() Option {
+	return func(o *reloaderOptions) {
+		o.cfg.EnableOCSPStapling = true
+	}
+}
+
+// WithKeyEncryptionPassphrase decrypts a key encrypted with the KeyEncryptionPassphrase of the Config
+func WithKeyEncryptionPassphrase(passphrase []byte) Option {
+	return func(o *reloaderOptions) {
+		o.cfg.KeyEncryptionPassphrase = passphrase
+	}
+}
+
+// WithCleanup sets the func called when a syscall.SIGINT or syscall.SIGABRT is received,
+// without one the process exits
+func WithCleanup(cleanup func()) Option {
+	return func(o *reloaderOptions) {
+		o.cleanup = cleanup
+	}
+}
+
+// NewReloaderOnly returns a CertReloader for a cert obtained elsewhere.
+// It loads the keypair from certPath and keyPath and reloads it when the files change
+// or the reload signal is received, but never contacts an ACME server:
+// there is no account, no renewal routine and no global config involved.
+// If a reload fails, the current cert is kept.
+func NewReloaderOnly(certPath, keyPath string, opts ...Option) (*CertReloader, error) {
+	o := reloaderOptions{
+		cfg: Config{WatchCertFiles: true},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	reloader := newCertReloader()
+	reloader.cfg = &o.cfg
+	reloader.reloadOnly = true
+
+	err := reloader.start(certPath, keyPath, nil, o.cleanup)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
@@ -0,0 +1,68 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewReloaderOnly(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		certPath = filepath.Join(dir, "tls.crt")
+		keyPath  = filepath.Join(dir, "tls.key")
+	)
+	writePair := func(validity time.Duration) {
+		cert := testCertResource(t, validity)
+		if err := os.WriteFile(certPath, cert.Certificate, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyPath, cert.PrivateKey, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePair(time.Hour)
+
+	// no global config is needed
+	reloader, err := NewReloaderOnly(certPath, keyPath, WithWatchInterval(10*time.Millisecond), WithoutReloadSignal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// replace the files out of band
+	old := reloader.NotAfter()
+	writePair(48 * time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reloader.NotAfter().Equal(old) {
+		if time.Now().After(deadline) {
+			t.Fatal("cert has not been reloaded after the files changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a broken cert is not loaded and there is no backup to restore
+	loaded := reloader.NotAfter()
+	if err := os.WriteFile(certPath, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	reloader.ReloadNow()
+	if !reloader.NotAfter().Equal(loaded) {
+		t.Fatal("expected the current cert to be kept")
+	}
+	if _, err := os.Stat(certPath); err != nil {
+		t.Fatal("expected the cert file to be left alone: ", err)
+	}
+
+	if _, err := NewReloaderOnly(filepath.Join(dir, "missing.crt"), keyPath); err == nil {
+		t.Fatal("expected an error for a missing cert")
+	}
+}
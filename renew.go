@@ -6,22 +6,43 @@
 package simplecert
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"syscall"
 	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
 )
 
-func renew(cert *certificate.Resource) error {
+// defaultRenewRetryInterval is the wait time between failed renewal attempts if no RenewRetryInterval is configured
+const defaultRenewRetryInterval = 10 * time.Minute
+
+// renew checks the certificate against the renewBefore threshold and renews it if necessary.
+// the caller must hold the cacheDir lock.
+func renew(cert *certificate.Resource, reloader *CertReloader) error {
+	// another process sharing the cacheDir might have renewed the certificate already
+	// in that case, pick up the new certificate from disk instead of contacting the CA again
+	cached, err := readCertResource(c, filepath.Join(c.CacheDir, c.certResourceFile()))
+	if err == nil && !bytes.Equal(cached.Certificate, cert.Certificate) {
+		log.Println("[INFO] simplecert: certificate in cacheDir has been updated by another process, loading it")
+		*cert = *cached
+
+		err = triggerReload(reloader)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Input certificate is PEM encoded. Decode it here as we may need the decoded
 	// cert later on in the renewal process. The input may be a bundle or a single certificate.
 	certificates, err := parsePEMBundle(cert.Certificate)
 	if err != nil {
-		return fmt.Errorf("simplecert: failed to parsePEMBundle: %s", err)
+		return fmt.Errorf("simplecert: failed to parsePEMBundle: %w", err)
 	}
 
 	// check if first cert is CA
@@ -30,109 +51,369 @@ func renew(cert *certificate.Resource) error {
 		return fmt.Errorf("[%s] Certificate bundle starts with a CA certificate", cert.Domain)
 	}
 
+	// the cert on disk might have been replaced without updating the certificate resource
+	notAfter := x509Cert.NotAfter
+	if diskNotAfter, err := certFileNotAfter(filepath.Join(c.CacheDir, c.certFile())); err != nil {
+		log.Println("[WARNING] simplecert: failed to read the expiry of the cert on disk: ", err)
+	} else if diskNotAfter.Before(notAfter) {
+		log.Println("[WARNING] simplecert: cert on disk expires before the one in the certificate resource, using its expiry:", diskNotAfter.Format(time.RFC1123))
+		notAfter = diskNotAfter
+	}
+
 	// Calculate TimeLeft
-	timeLeft := x509Cert.NotAfter.Sub(time.Now().UTC())
-	log.Printf("[INFO][%s] acme: %d hours remaining, renewBefore: %d\n", cert.Domain, int(timeLeft.Hours()), int(c.RenewBefore))
+	timeLeft := notAfter.Sub(time.Now().UTC())
+	log.Printf("[INFO][%s] acme: %d hours remaining, renewBefore: %d\n", cert.Domain, int(timeLeft.Hours()), int(c.renewBefore().Hours()))
 
-	// Check against renewBefore
-	if int(timeLeft.Hours()) <= int(c.RenewBefore) {
-		log.Println("[INFO] simplecert: renewing cert...")
+	// Check against renewBefore, the local clock might be behind by up to MaxClockSkew
+	due := timeLeft <= c.renewBefore()+c.MaxClockSkew
+	if c.ShouldRenew != nil {
+		due = c.ShouldRenew(x509Cert)
+	}
 
-		// allow graceful shutdown of running services if required
-		if c.WillRenewCertificate != nil {
-			c.WillRenewCertificate()
+	// ask the CA for the suggested renewal window instead
+	// fall back to renewBefore if the CA does not support ARI
+	var client acmeClient
+	if c.UseARI {
+		client, err = newACMEClient()
+		if err == nil {
+			var (
+				dueARI  bool
+				renewAt time.Time
+			)
+			dueARI, renewAt, err = checkARI(client, x509Cert)
+			if err == nil {
+				due = dueARI
+				reloader.setARIRenewAt(renewAt)
+			}
 		}
-
-		u, err := getUser()
 		if err != nil {
-			return fmt.Errorf("simplecert: failed to get acme user: %s", err)
+			log.Println("[WARNING] simplecert: failed to get renewal info, falling back to renewBefore: ", err)
+		}
+	}
+
+	// do not wait for the suggested window if the cert might have expired already
+	if !due && timeLeft <= c.MaxClockSkew {
+		log.Println("[WARNING] simplecert: cert expires within MaxClockSkew, renewing it now")
+		due = true
+	}
+
+	if due {
+		logEvent(slog.LevelInfo, "renewing cert", "domains", c.Domains, "not_after", x509Cert.NotAfter)
+
+		// allow graceful shutdown of running services if required
+		if c.WillRenewCertificate != nil {
+			callHook("WillRenewCertificate", c.WillRenewCertificate)
 		}
 
 		// get ACME Client
-		client, err := createClient(u, c.DNSServers)
-		if err != nil {
-			return fmt.Errorf("simplecert: failed to create lego.Client: %s", err)
+		if client == nil {
+			client, err = newACMEClient()
+			if err != nil {
+				return err
+			}
 		}
 
 		// start renewal
 		// bundle CA with certificate to avoid "transport: x509: certificate signed by unknown authority" error
-		cert, err := client.Certificate.Renew(*cert, true, false, "")
+		var newCert *certificate.Resource
+		err = withChallengeServers("renewing cert", func() (errRenew error) {
+			newCert, errRenew = client.RenewWithOptions(*cert, &certificate.RenewOptions{
+				Bundle:         true,
+				MustStaple:     c.MustStaple,
+				PreferredChain: c.PreferredChain,
+				Profile:        c.Profile,
+			})
+			return errRenew
+		})
 		if err != nil {
-			return fmt.Errorf("simplecert: failed to renew cert: %s", err)
+			return fmt.Errorf("%w: %w", ErrRenewFailed, err)
 		}
 
 		// if we made it here we got a new cert
+		checkPreferredChain(newCert)
+
 		// backup old cert and key
 		// create a new directory for those in cacheDir, named backup-{currentDate}-{currentTime}
-		backupDate = time.Now().Format("2006-January-02-1504")
-		err = os.MkdirAll(filepath.Join(c.CacheDir, "backup-"+backupDate), c.CacheDirPerm)
+		backupDir := filepath.Join(c.CacheDir, "backup-"+time.Now().Format("2006-January-02-1504"))
+		err = os.MkdirAll(backupDir, c.CacheDirPerm)
 		if err != nil {
-			return fmt.Errorf("simplecert: failed to create backup dir: %s", err)
+			return fmt.Errorf("simplecert: failed to create backup dir: %w", err)
 		}
 
 		// backup private key
-		err = os.Rename(filepath.Join(c.CacheDir, keyFileName), filepath.Join(c.CacheDir, "backup-"+backupDate, keyFileName))
-		if err != nil {
-			return fmt.Errorf("simplecert: failed to move key into backup dir: %s", err)
+		// the files are copied, so a valid cert and key stay in place until the new ones are written
+		// certificates obtained for a CSR have no private key in the cacheDir
+		if len(cert.PrivateKey) > 0 {
+			err = copyFile(filepath.Join(c.CacheDir, c.keyFile()), filepath.Join(backupDir, c.keyFile()))
+			if err != nil {
+				return fmt.Errorf("simplecert: failed to copy key into backup dir: %w", err)
+			}
 		}
 
 		// backup certificate
-		err = os.Rename(filepath.Join(c.CacheDir, certFileName), filepath.Join(c.CacheDir, "backup-"+backupDate, keyFileName))
+		err = copyFile(filepath.Join(c.CacheDir, c.certFile()), filepath.Join(backupDir, c.certFile()))
 		if err != nil {
-			return fmt.Errorf("simplecert: failed to move cert into backup dir: %s", err)
+			return fmt.Errorf("simplecert: failed to copy cert into backup dir: %w", err)
 		}
 
+		// remember the backup of this cert, to restore it if the new cert can not be loaded
+		reloader.setBackupDir(backupDir)
+
 		// Save new cert to disk
-		err = saveCertToDisk(cert, c.CacheDir)
+		err = saveCertToDisk(newCert, c.CacheDir)
 		if err != nil {
-			return fmt.Errorf("simplecert: failed to write new cert to disk: %s", err)
+			return fmt.Errorf("simplecert: failed to write new cert to disk: %w", err)
 		}
 
-		log.Println("[INFO] simplecert: wrote new cert to disk!")
+		// keep track of the new cert, the next check must use it
+		*cert = *newCert
+
+		notifyCTLogs(cert)
+		notifyCertChanged(cert)
+
+		logEvent(slog.LevelInfo, "renewed cert", "domains", c.Domains, "not_after", certNotAfter(cert))
 
 		// allow service restart if required
 		if c.DidRenewCertificate != nil {
-			c.DidRenewCertificate()
+			callHook("DidRenewCertificate", c.DidRenewCertificate)
 		} else {
 			// if the user has not specified a DidRenewCertificate handler to restart the service
 			// we will force the server to reload the cert by sending a HUP signal
-			log.Println("[INFO] triggering reload via SIGHUP")
-
-			// trigger reload by sending our process a SIGHUP
-			p, err := os.FindProcess(os.Getpid())
+			err = triggerReload(reloader)
 			if err != nil {
-				return fmt.Errorf("simplecert: failed to get process by PID: %s", err)
+				return err
 			}
+		}
+	}
 
-			// send signal
-			err = p.Signal(syscall.SIGHUP)
-			if err != nil {
-				return fmt.Errorf("simplecert: failed to send SIGHUP to our process: %s", err)
-			}
+	return nil
+}
+
+// callHook calls the renewal hook and waits at most HookTimeout for it to return.
+// On timeout the renewal proceeds, while the hook keeps running in the background.
+// configMu is released while waiting, the caller must hold it.
+func callHook(name string, hook func()) {
+	timeout := c.HookTimeout
+
+	withoutConfigLock(func() {
+		if timeout <= 0 {
+			hook()
+			return
 		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			hook()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			log.Println("[WARNING] simplecert:", name, "did not return within", timeout, "proceeding with the renewal")
+		}
+	})
+}
+
+// certFileNotAfter returns the expiry of the leaf in the cert file at path
+func certFileNotAfter(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	certs, err := parsePEMBundle(data)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return certs[0].NotAfter, nil
+}
+
+// triggerReload forces the CertReloader to load the cert from disk by sending our process the reload signal
+// if reloading via signal is disabled, the reloader is invoked directly
+func triggerReload(reloader *CertReloader) error {
+	if c.DisableReloadSignal {
+		log.Println("[INFO] reloading the cert")
+		reloader.ReloadNow()
+		return nil
+	}
+
+	sig := c.reloadSignal()
+	log.Println("[INFO] triggering reload via", sig)
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to get process by PID: %w", err)
+	}
+
+	// send signal
+	err = p.Signal(sig)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to send %s to our process: %w", sig, err)
 	}
 
 	return nil
 }
 
-// take care of checking the cert in the configured interval
-// and renew if timeLeft is less than or equal to renewBefore
+// startRenewalRoutine schedules the first renewal check and starts the renewal routine,
+// the schedule is set before returning so NextRenewal is available right after Init
+func startRenewalRoutine(cr *certificate.Resource, reloader *CertReloader) {
+	jitter := renewJitter()
+
+	wait := nextCheck(cr, reloader, jitter)
+	reloader.setNextRenewal(wait)
+
+	go renewalRoutine(cr, reloader, jitter, wait)
+}
+
+// take care of renewing the cert once timeLeft is less than or equal to renewBefore
+// the routine sleeps until the renewal time computed from the expiry of the cert,
+// and falls back to checking in the configured interval if the expiry is unknown
 // when initially started, the certificate is checked against the thresholds and renewed if neccessary
-func renewalRoutine(cr *certificate.Resource) {
+func renewalRoutine(cr *certificate.Resource, reloader *CertReloader, jitter, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	// consecutive failed renewals
+	var failures int
+
 	for {
-		// sleep for duration of checkInterval
-		time.Sleep(c.CheckInterval)
+		// sleep until the next check
+		// or exit if the reloader has been closed
+		select {
+		case <-reloader.stop:
+			log.Println("[INFO] simplecert: stopped renewal routine")
+			return
+		case <-timer.C:
+		}
 
-		// renew the certificate
-		err := renew(cr)
+		// certs of a group are renewed with the config of their group
+		restore := useConfig(reloader.cfg)
+		var failed func(error)
+
+		// renew the certificate while holding the cacheDir lock
+		err := renewLocked(cr, reloader)
+		wait = nextCheck(cr, reloader, jitter)
 		if err != nil { // something went wrong.
-			// call handler if set
-			if c.FailedToRenewCertificate != nil {
-				c.FailedToRenewCertificate(err)
+			failures++
+
+			// retry sooner, the current cert is still served in the meantime
+			retryWait, retry := renewRetryWait(failures, reloader.NotAfter())
+			if retry {
+				wait = retryWait
+			}
+
+			// do not check again before the rate limit has expired
+			var rateLimited *ErrRateLimited
+			if errors.As(err, &rateLimited) && time.Until(rateLimited.RetryAfter) > wait {
+				wait = time.Until(rateLimited.RetryAfter)
+				log.Println("[WARNING] simplecert: rate limited, next renewal attempt in", wait)
+			}
+
+			logEvent(slog.LevelError, "failed to renew cert", "domains", c.Domains, "attempt", failures, "err", err)
+			reportError("renew", err)
+
+			if retry {
+				log.Printf("[WARNING] simplecert: renewal attempt %d/%d failed, retrying in %s: %s\n", failures, c.MaxRenewAttempts, wait, err)
 			} else {
-				// otherwise fatal
-				log.Fatal("[FATAL] failed to renew cert: ", err.Error())
+				failures = 0
+				failed = c.FailedToRenewCertificate
+				if failed == nil {
+					// fatal if no handler is set
+					log.Fatal("[FATAL] failed to renew cert: ", err.Error())
+				}
 			}
+		} else {
+			failures = 0
 		}
+
+		restore()
+
+		// the handler is called without holding any lock, it may call Init, Revoke or RollbackCert
+		if failed != nil {
+			failed(err)
+		}
+
+		reloader.setNextRenewal(wait)
+		timer.Reset(wait)
+	}
+}
+
+// renewRetryWait returns the wait until retrying a renewal after the given number of consecutive failures.
+// It returns false once MaxRenewAttempts is exhausted, or if the cert expiring at notAfter
+// would not be valid anymore at the next attempt.
+func renewRetryWait(failures int, notAfter time.Time) (time.Duration, bool) {
+	if failures >= c.MaxRenewAttempts {
+		return 0, false
 	}
+
+	wait := c.RenewRetryInterval
+	if wait <= 0 {
+		wait = min(defaultRenewRetryInterval, c.CheckInterval)
+	}
+
+	if time.Now().Add(wait).After(notAfter) {
+		return 0, false
+	}
+	return wait, true
+}
+
+// nextCheck returns the duration until the next check:
+// the renewal time scheduled via ARI if there is one, or else
+// the time at which the cert enters the renewBefore window, moved forward by MaxClockSkew and delayed by the jitter.
+// The checkInterval is used when ARI or ShouldRenew is enabled, to fetch the renewal window again,
+// when the expiry can not be parsed, and to retry a renewal that is already overdue.
+func nextCheck(cr *certificate.Resource, reloader *CertReloader, jitter time.Duration) time.Duration {
+	if renewAt := reloader.takeARIRenewAt(); !renewAt.IsZero() {
+		wait := time.Until(renewAt)
+		if wait < c.CheckInterval {
+			return wait
+		}
+	}
+	// the renewal time can not be computed for ARI or a custom ShouldRenew
+	if c.UseARI || c.ShouldRenew != nil {
+		return c.CheckInterval
+	}
+
+	certs, err := parsePEMBundle(cr.Certificate)
+	if err != nil {
+		log.Println("[WARNING] simplecert: failed to parse cert expiry, checking again in", c.CheckInterval, ": ", err)
+		return c.CheckInterval
+	}
+
+	wait := time.Until(certs[0].NotAfter.Add(-c.renewBefore()-c.MaxClockSkew)) + jitter
+	if wait <= 0 {
+		return c.CheckInterval
+	}
+
+	logEvent(slog.LevelInfo, "renewal scheduled", "domains", c.Domains, "next_renewal", time.Now().Add(wait).Round(time.Second))
+	return wait
+}
+
+// renewJitter returns the offset for the renewals of this instance,
+// derived from the hostname and the domains so it is stable across restarts
+func renewJitter() time.Duration {
+	if c.RenewJitter <= 0 {
+		return 0
+	}
+
+	hostname, _ := os.Hostname()
+
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	for _, d := range c.Domains {
+		h.Write([]byte(d))
+	}
+
+	return time.Duration(h.Sum64() % uint64(c.RenewJitter))
+}
+
+// renewLocked acquires the cacheDir lock and renews the certificate if necessary
+func renewLocked(cr *certificate.Resource, reloader *CertReloader) error {
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
+	return renew(cr, reloader)
 }
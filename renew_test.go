@@ -0,0 +1,495 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// fakeClient implements acmeClient without contacting a CA
+type fakeClient struct {
+	t         *testing.T
+	renewed   int
+	renewErr  error
+	revokeErr error
+	profile   string
+	ariWindow acme.Window
+}
+
+func (f *fakeClient) Obtain(certificate.ObtainRequest) (*certificate.Resource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeClient) ObtainForCSR(certificate.ObtainForCSRRequest) (*certificate.Resource, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeClient) RenewWithOptions(certRes certificate.Resource, options *certificate.RenewOptions) (*certificate.Resource, error) {
+	f.renewed++
+	f.profile = options.Profile
+	if f.renewErr != nil {
+		return nil, f.renewErr
+	}
+	return testCertResource(f.t, 90*24*time.Hour), nil
+}
+
+func (f *fakeClient) RevokeWithReason(cert []byte, reason *uint) error {
+	if f.revokeErr != nil {
+		return f.revokeErr
+	}
+	return errors.New("not implemented")
+}
+
+func (f *fakeClient) GetRenewalInfo(certificate.RenewalInfoRequest) (*certificate.RenewalInfoResponse, error) {
+	if f.ariWindow.Start.IsZero() {
+		return nil, errors.New("not implemented")
+	}
+	return &certificate.RenewalInfoResponse{RenewalInfoResponse: acme.RenewalInfoResponse{SuggestedWindow: f.ariWindow}}, nil
+}
+
+func TestRenew(t *testing.T) {
+	tests := []struct {
+		name      string
+		validity  time.Duration
+		renewErr  error
+		wantRenew bool
+		wantErr   bool
+	}{
+		{"not due", 60 * 24 * time.Hour, nil, false, false},
+		{"due", 10 * 24 * time.Hour, nil, true, false},
+		{"failed", 10 * 24 * time.Hour, errors.New("renewal failed"), true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeClient{t: t, renewErr: tt.renewErr}
+			didRenew := false
+			setupRenewTest(t, fake)
+			c.DidRenewCertificate = func() { didRenew = true }
+
+			cert := testCertResource(t, tt.validity)
+			if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+				t.Fatal(err)
+			}
+			old := cert.Certificate
+
+			err := renew(cert, newCertReloader())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renew() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && (!errors.Is(err, tt.renewErr) || !errors.Is(err, ErrRenewFailed)) {
+				t.Errorf("expected error to wrap %v and ErrRenewFailed, got %v", tt.renewErr, err)
+			}
+			if (fake.renewed > 0) != tt.wantRenew {
+				t.Fatalf("renewed %d times, wantRenew %v", fake.renewed, tt.wantRenew)
+			}
+
+			// a successful renewal replaces the cert and calls the handler
+			renewed := tt.wantRenew && !tt.wantErr
+			if didRenew != renewed {
+				t.Errorf("DidRenewCertificate called: %v, want %v", didRenew, renewed)
+			}
+			if changed := string(cert.Certificate) != string(old); changed != renewed {
+				t.Errorf("cert changed: %v, want %v", changed, renewed)
+			}
+		})
+	}
+}
+
+func TestRenewCertFileExpiry(t *testing.T) {
+	fake := &fakeClient{t: t}
+	setupRenewTest(t, fake)
+	c.DidRenewCertificate = func() {}
+
+	// the certificate resource is not due, but the cert on disk has been replaced by one that is
+	cert := testCertResource(t, 60*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+	replaced := testCertResource(t, 10*24*time.Hour)
+	if err := os.WriteFile(filepath.Join(c.CacheDir, certFileName), replaced.Certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := renew(cert, newCertReloader()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.renewed != 1 {
+		t.Fatalf("renewed %d times, want 1", fake.renewed)
+	}
+}
+
+func TestRenewMaxClockSkew(t *testing.T) {
+	tests := []struct {
+		name      string
+		skew      time.Duration
+		wantRenew bool
+	}{
+		{"no skew", 0, false},
+		{"within skew", 2 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeClient{t: t}
+			setupRenewTest(t, fake)
+			c.DidRenewCertificate = func() {}
+			c.MaxClockSkew = tt.skew
+
+			// the cert enters the renewBefore window in an hour
+			cert := testCertResource(t, 30*24*time.Hour+time.Hour)
+			if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+				t.Fatal(err)
+			}
+			// an overdue renewal is retried in the CheckInterval
+			wait := nextCheck(cert, newCertReloader(), 0)
+			if overdue := wait == c.CheckInterval; overdue != tt.wantRenew {
+				t.Errorf("next check in %s, want overdue %v", wait, tt.wantRenew)
+			}
+
+			if err := renew(cert, newCertReloader()); err != nil {
+				t.Fatal(err)
+			}
+			if (fake.renewed > 0) != tt.wantRenew {
+				t.Fatalf("renewed %d times, wantRenew %v", fake.renewed, tt.wantRenew)
+			}
+		})
+	}
+}
+
+func TestRenewShouldRenew(t *testing.T) {
+	tests := []struct {
+		name        string
+		validity    time.Duration
+		shouldRenew bool
+	}{
+		{"renew early", 60 * 24 * time.Hour, true},
+		{"postpone", 10 * 24 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeClient{t: t}
+			setupRenewTest(t, fake)
+			c.DidRenewCertificate = func() {}
+
+			var called bool
+			c.ShouldRenew = func(cert *x509.Certificate) bool {
+				called = cert.Subject.CommonName == "example.com"
+				return tt.shouldRenew
+			}
+
+			cert := testCertResource(t, tt.validity)
+			if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+				t.Fatal(err)
+			}
+			if err := renew(cert, newCertReloader()); err != nil {
+				t.Fatal(err)
+			}
+			if !called {
+				t.Fatal("expected ShouldRenew to be called with the leaf")
+			}
+			if (fake.renewed > 0) != tt.shouldRenew {
+				t.Fatalf("renewed %d times, want renewal %v", fake.renewed, tt.shouldRenew)
+			}
+
+			// the decision is polled
+			if wait := nextCheck(cert, newCertReloader(), 0); wait != c.CheckInterval {
+				t.Errorf("expected the next check in the CheckInterval, got %s", wait)
+			}
+		})
+	}
+}
+
+func TestRenewRetryWait(t *testing.T) {
+	setConfig(&Config{CheckInterval: time.Hour, MaxRenewAttempts: 3})
+	defer setConfig(nil)
+
+	valid := time.Now().Add(24 * time.Hour)
+	tests := []struct {
+		name      string
+		failures  int
+		notAfter  time.Time
+		interval  time.Duration
+		wantWait  time.Duration
+		wantRetry bool
+	}{
+		{"first failure", 1, valid, 0, defaultRenewRetryInterval, true},
+		{"custom interval", 2, valid, time.Minute, time.Minute, true},
+		{"attempts exhausted", 3, valid, 0, 0, false},
+		{"cert expires before the next attempt", 1, time.Now().Add(time.Minute), 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.RenewRetryInterval = tt.interval
+			wait, retry := renewRetryWait(tt.failures, tt.notAfter)
+			if wait != tt.wantWait || retry != tt.wantRetry {
+				t.Errorf("renewRetryWait(%d) = %s, %v, want %s, %v", tt.failures, wait, retry, tt.wantWait, tt.wantRetry)
+			}
+		})
+	}
+
+	// the handler is called on the first failure by default
+	c.MaxRenewAttempts = 0
+	if _, retry := renewRetryWait(1, valid); retry {
+		t.Error("expected no retry without MaxRenewAttempts")
+	}
+}
+
+func TestRenewProfile(t *testing.T) {
+	fake := &fakeClient{t: t}
+	setupRenewTest(t, fake)
+	c.DidRenewCertificate = func() {}
+	c.Profile = "shortlived"
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := renew(cert, newCertReloader()); err != nil {
+		t.Fatal(err)
+	}
+	if fake.profile != "shortlived" {
+		t.Fatalf("renewed with profile %q, want shortlived", fake.profile)
+	}
+}
+
+func TestRenewHookWithoutConfigLock(t *testing.T) {
+	fake := &fakeClient{t: t}
+	setupRenewTest(t, fake)
+	cfg := c
+
+	// the hook can use functions locking configMu
+	c.DidRenewCertificate = func() {
+		configMu.Lock()
+		setConfig(&Config{})
+		configMu.Unlock()
+	}
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- renew(cert, newCertReloader()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewal deadlocked in the hook")
+	}
+
+	// the config of the renewal is restored
+	if c != cfg {
+		t.Fatal("expected the config of the renewal to be restored")
+	}
+}
+
+func TestCertChangedAfterUnlock(t *testing.T) {
+	fake := &fakeClient{t: t}
+	setupRenewTest(t, fake)
+	cfg := c
+	cfg.DidRenewCertificate = func() {}
+
+	// the handler runs once the renewal has released configMu and the cacheDir lock
+	var called bool
+	cfg.OnCertChanged = func(cert, key []byte) error {
+		called = true
+		if !configMu.TryLock() {
+			t.Error("OnCertChanged called while holding configMu")
+		} else {
+			configMu.Unlock()
+		}
+
+		f, err := os.OpenFile(filepath.Join(cfg.CacheDir, lockFileName), os.O_RDWR, 0)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer f.Close()
+		if err := tryLockFile(f); err != nil {
+			t.Error("OnCertChanged called while holding the cacheDir lock")
+		} else {
+			unlockFile(f)
+		}
+		return nil
+	}
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// renew like the renewal routine
+	var err error
+	withoutConfigLock(func() {
+		restore := useConfig(cfg)
+		err = renewLocked(cert, newCertReloader())
+		restore()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("expected OnCertChanged to be called")
+	}
+}
+
+func TestRenewARIPerReloader(t *testing.T) {
+	renewAt := time.Now().Add(10 * time.Minute)
+	fake := &fakeClient{t: t, ariWindow: acme.Window{Start: renewAt, End: renewAt}}
+	setupRenewTest(t, fake)
+	c.UseARI = true
+
+	cert := testCertResource(t, 60*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the suggested time is only used for the cert it was fetched for
+	reloader, other := newCertReloader(), newCertReloader()
+	if err := renew(cert, reloader); err != nil {
+		t.Fatal(err)
+	}
+	if wait := nextCheck(cert, other, 0); wait != c.CheckInterval {
+		t.Errorf("expected the other cert to be checked in the CheckInterval, got %s", wait)
+	}
+	if wait := nextCheck(cert, reloader, 0); wait > 10*time.Minute || wait < 9*time.Minute {
+		t.Errorf("expected the renewal at the suggested time, got %s", wait)
+	}
+}
+
+func TestRenewBackupPerReloader(t *testing.T) {
+	setupRenewTest(t, &fakeClient{t: t})
+	c.DidRenewCertificate = func() {}
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// the backup is only restored for the cert it was created for
+	reloader, other := newCertReloader(), newCertReloader()
+	if err := renew(cert, reloader); err != nil {
+		t.Fatal(err)
+	}
+	if dir := other.lastBackupDir(); dir != "" {
+		t.Errorf("expected no backup for the other cert, got %s", dir)
+	}
+	dir := reloader.lastBackupDir()
+	if filepath.Dir(dir) != c.CacheDir {
+		t.Fatalf("expected the backup in the cacheDir, got %q", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, c.certFile())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenewHookTimeout(t *testing.T) {
+	fake := &fakeClient{t: t}
+	setupRenewTest(t, fake)
+	c.HookTimeout = 50 * time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+	c.WillRenewCertificate = func() { <-release }
+	c.DidRenewCertificate = func() {}
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- renew(cert, newCertReloader()) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("renewal blocked on a hung WillRenewCertificate hook")
+	}
+	if fake.renewed != 1 {
+		t.Errorf("renewed %d times, want 1", fake.renewed)
+	}
+}
+
+// setupRenewTest sets a config using a temporary cacheDir and the fake client
+func setupRenewTest(t *testing.T, fake *fakeClient) {
+	t.Helper()
+
+	// renew is called with configMu held
+	lockConfig()
+	setConfig(&Config{
+		CacheDir:      t.TempDir(),
+		CacheDirPerm:  0700,
+		Domains:       []string{"example.com"},
+		RenewBefore:   30 * 24,
+		CheckInterval: time.Hour,
+	})
+
+	newClient := newACMEClient
+	newACMEClient = func() (acmeClient, error) { return fake, nil }
+
+	t.Cleanup(func() {
+		setConfig(nil)
+		newACMEClient = newClient
+		unlockConfig()
+	})
+}
+
+// testCertResource returns a certificate resource with a self signed cert for example.com
+func testCertResource(t *testing.T, validity time.Duration) *certificate.Resource {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(validity),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &certificate.Resource{
+		Domain:      "example.com",
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
@@ -0,0 +1,109 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// InitFromResources adopts an ACME account exported with ExportAccount and a certificate resource,
+// e.g. baked into an image, and takes over the renewals like Init.
+// Both are written to the CacheDir, which must be writable, unless it holds them already.
+// A cached cert that expires later than the supplied one, e.g. after a renewal, is kept.
+// The account or the cert can be nil, to register a new account or obtain a new cert like Init.
+func InitFromResources(cfg *Config, account []byte, cert *CR, cleanup func()) (*CertReloader, error) {
+	if len(cfg.CertGroups) > 0 {
+		return nil, errCertGroupsInit
+	}
+
+	// apply environment overrides and validate config
+	applyEnv(cfg)
+	err := CheckConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Local {
+		return nil, errLocalResources
+	}
+
+	lockConfig()
+	defer unlockConfig()
+
+	// update global config
+	setConfig(cfg)
+
+	// make sure the cacheDir exists
+	ensureCacheDirExists(c.CacheDir)
+
+	if account != nil {
+		err = adoptAccount(account)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cert != nil {
+		err = adoptCert(cert)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return initReloader(cfg, cleanup, nil)
+}
+
+// adoptAccount stores the account, unless it is stored already
+func adoptAccount(account []byte) error {
+	u, err := parseAccount(account)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(filepath.Join(c.accountDir(), sslUserFileName))
+	if err == nil {
+		stored, err := parseAccount(b)
+		if err == nil && stored.Registration.URI == u.Registration.URI && stored.Key.Equal(u.Key) {
+			return nil
+		}
+	}
+
+	log.Println("[INFO] simplecert: storing the supplied account", u.Registration.URI)
+	return ImportAccount(c, account)
+}
+
+// adoptCert writes the certificate resource to the cacheDir,
+// unless the cached cert is the same or expires later
+func adoptCert(cr *CR) error {
+	cert := getACMECertResource(*cr)
+	if _, err := tls.X509KeyPair(cert.Certificate, cert.PrivateKey); err != nil {
+		return fmt.Errorf("simplecert: invalid certificate resource: %w", err)
+	}
+
+	// acquire the cacheDir lock, so a cert renewed by another process is not overwritten
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
+	cached, err := readCertResource(c, filepath.Join(c.CacheDir, c.certResourceFile()))
+	if err == nil {
+		if bytes.Equal(cached.Certificate, cert.Certificate) {
+			return nil
+		}
+		if certNotAfter(cached).After(certNotAfter(cert)) {
+			log.Println("[INFO] simplecert: cached cert expires after the supplied one, keeping it")
+			return nil
+		}
+	}
+
+	log.Println("[INFO] simplecert: storing the supplied cert for", cr.Domain)
+	return saveCertToDisk(cert, c.CacheDir)
+}
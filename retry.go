@@ -0,0 +1,133 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+// used if ObtainRetries is set, but no ObtainRetryBackoff has been configured
+const defaultRetryBackoff = 5 * time.Second
+
+// withRetry executes fn and retries it with exponential backoff
+// as long as it fails with a transient error and the configured number of retries is not exhausted
+func withRetry(action string, fn func() error) error {
+	backoff := c.ObtainRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		// do not retry if the next attempt would start after the ObtainTimeout
+		if attempt > c.ObtainRetries || !isTransientError(err) || !obtainUntil.IsZero() && time.Now().Add(backoff).After(obtainUntil) {
+			// rate limits are surfaced as ErrRateLimited
+			return asRateLimitError(err)
+		}
+
+		log.Printf("[WARNING] simplecert: %s failed with a transient error (attempt %d/%d), retrying in %s: %s\n", action, attempt, c.ObtainRetries+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// obtainDeadline returns the time by which a cert must have been obtained, zero if no ObtainTimeout is set
+func obtainDeadline() time.Time {
+	if c.ObtainTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ObtainTimeout)
+}
+
+// obtainUntil is the deadline of the cert currently obtained by Init or ObtainWithCSR,
+// zero if there is none, e.g. during renewals. It is protected by configMu.
+var obtainUntil time.Time
+
+// withDeadline executes fn with the requests of the ACME clients it creates bounded by the deadline,
+// and returns ErrObtainTimeout if fn fails once the deadline has passed.
+// lego does not support cancellation, so fn is not abandoned, it fails once its requests do.
+// A cert that has been issued in the meantime is returned by fn and can still be saved.
+func withDeadline(deadline time.Time, fn func() error) error {
+	if deadline.IsZero() {
+		return fn()
+	}
+
+	obtainUntil = deadline
+	defer func() { obtainUntil = time.Time{} }()
+
+	err := fn()
+	if err != nil && !time.Now().Before(deadline) {
+		return fmt.Errorf("%w after %s: %w", ErrObtainTimeout, c.ObtainTimeout, err)
+	}
+	return err
+}
+
+// deadlineClient returns a copy of client that fails all requests once the deadline has passed
+func deadlineClient(client *http.Client, deadline time.Time) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	bounded := *client
+	bounded.Transport = &deadlineTransport{base: base, deadline: deadline}
+	return &bounded
+}
+
+// deadlineTransport sets the deadline on the context of every request
+type deadlineTransport struct {
+	base     http.RoundTripper
+	deadline time.Time
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithDeadline(req.Context(), t.deadline)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the context must stay alive until the body has been read
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody cancels the context of its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// isTransientError reports whether err is worth a retry:
+// network errors and server side errors of the CA are transient,
+// while all other problems reported by the CA (rate limits, invalid domains, unauthorized...) are permanent
+func isTransientError(err error) bool {
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) {
+		return problem.HTTPStatus >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,90 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestObtainTimeout(t *testing.T) {
+	// a CA that does not answer the account registration, until the requests fail at the deadline
+	var returned bool
+	newClient := newACMEClient
+	newACMEClient = func() (acmeClient, error) {
+		if obtainUntil.IsZero() {
+			return nil, errors.New("no deadline set for the requests")
+		}
+		time.Sleep(time.Until(obtainUntil))
+		returned = true
+		return nil, errors.New("context deadline exceeded")
+	}
+	defer func() { newACMEClient = newClient }()
+
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CacheDir = t.TempDir()
+	cfg.LogFile = ""
+	cfg.HTTPAddress = ""
+	cfg.TLSAddress = ""
+	cfg.HTTPChallengeHandler = true
+	cfg.ObtainTimeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := Init(&cfg, nil)
+	if !errors.Is(err, ErrObtainTimeout) {
+		t.Fatalf("expected %v, got %v", ErrObtainTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Init returned after %s", elapsed)
+	}
+
+	// nothing keeps running in the background
+	if !returned {
+		t.Fatal("Init returned before the registration")
+	}
+	if !obtainUntil.IsZero() {
+		t.Fatal("expected the deadline to be reset")
+	}
+}
+
+func TestDeadlineClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	client := deadlineClient(&http.Client{}, time.Now().Add(100*time.Millisecond))
+
+	// requests before the deadline succeed
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("got %q, %v", body, err)
+	}
+
+	// a hanging request fails at the deadline
+	start := time.Now()
+	if _, err := client.Get(srv.URL + "/slow"); err == nil {
+		t.Fatal("expected the request to fail at the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("request returned after %s", elapsed)
+	}
+}
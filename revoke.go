@@ -0,0 +1,84 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-acme/lego/v4/acme"
+)
+
+// ErrNoCachedCert is returned by Revoke if there is no cert in the cacheDir
+var ErrNoCachedCert = errors.New("simplecert: no cached certificate to revoke")
+
+// Revoke revokes the cert cached in the cacheDir of cfg with the supplied RFC 5280 reason code
+// (e.g. 0 for unspecified, 1 for key compromise, 4 for superseded, 5 for cessation of operation).
+// If cfg.DeleteRevokedCert is set, the cached cert and key are deleted afterwards,
+// so the next call to Init obtains a fresh cert.
+func Revoke(cfg *Config, reason int) error {
+	// RFC 5280 reason codes are 0-10, 7 is unused
+	if reason < int(acme.CRLReasonUnspecified) || reason > int(acme.CRLReasonAACompromise) || reason == 7 {
+		return fmt.Errorf("simplecert: invalid revocation reason: %d", reason)
+	}
+
+	// apply environment overrides and validate config
+	applyEnv(cfg)
+	err := CheckConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	// update global config
+	lockConfig()
+	defer unlockConfig()
+	setConfig(cfg)
+
+	// acquire the cacheDir lock, so the cert is not renewed concurrently
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
+	if !csrCertCached(c.CacheDir) {
+		return ErrNoCachedCert
+	}
+
+	cert, err := readCertResource(c, filepath.Join(c.CacheDir, c.certResourceFile()))
+	if err != nil {
+		return fmt.Errorf("simplecert: %w", err)
+	}
+
+	// get ACME Client
+	client, err := newACMEClient()
+	if err != nil {
+		return err
+	}
+
+	r := uint(reason)
+	err = client.RevokeWithReason(cert.Certificate, &r)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to revoke cert: %w", err)
+	}
+
+	log.Println("[INFO] simplecert: revoked cert for domain: ", cert.Domain)
+
+	if c.DeleteRevokedCert {
+		for _, name := range []string{c.certResourceFile(), c.certFile(), c.keyFile(), combinedPEMFileName} {
+			err = os.Remove(filepath.Join(c.CacheDir, name))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("simplecert: failed to delete revoked cert: %w", err)
+			}
+		}
+		log.Println("[INFO] simplecert: deleted revoked cert from cacheDir")
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevokeWrapsErrors(t *testing.T) {
+	cfg := *Default
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = []string{"example.com"}
+	cfg.CacheDir = t.TempDir()
+	cfg.FailedToRenewCertificate = func(error) {}
+
+	configMu.Lock()
+	setConfig(&cfg)
+	err := saveCertToDisk(testCertResource(t, time.Hour), cfg.CacheDir)
+	configMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCA := errors.New("ca error")
+	newClient := newACMEClient
+	newACMEClient = func() (acmeClient, error) { return &fakeClient{t: t, revokeErr: errCA}, nil }
+	defer func() {
+		newACMEClient = newClient
+		setConfig(nil)
+	}()
+
+	// callers can inspect the error of the CA
+	if err := Revoke(&cfg, 0); !errors.Is(err, errCA) {
+		t.Fatalf("expected the error to wrap the error of the CA, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CertInfo describes a cert found by ScanCacheDir
+type CertInfo struct {
+	// Path of the cert.pem file, or of the directory that could not be read if Err is set
+	Path     string
+	Domains  []string
+	NotAfter time.Time
+	// DaysLeft until the cert expires, negative if it has expired already
+	DaysLeft int
+
+	// Err is set if the cert or the directory could not be read or parsed, the other fields are empty then
+	Err error
+}
+
+// ScanCacheDir walks the directory tree at root and returns the domains and expiry of every cert.pem in it,
+// e.g. to audit the cacheDirs of all services on a host without loading their configs.
+// Directories and certs that can not be read, e.g. the cacheDir of another user, or can not be parsed
+// do not abort the scan, they are returned with the Err field set.
+// Archived certs and backups created during a renewal are skipped.
+// Certs stored with a custom CertFileName are not found.
+func ScanCacheDir(root string) ([]CertInfo, error) {
+	var infos []CertInfo
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// nothing to scan if the root can not be read
+			if path == root {
+				return err
+			}
+			infos = append(infos, CertInfo{Path: path, Err: err})
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if path != root && (d.Name() == archiveDirName || strings.HasPrefix(d.Name(), "backup-")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != certFileName {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			infos = append(infos, CertInfo{Path: path, Err: err})
+			return nil
+		}
+		certificates, err := parsePEMBundle(data)
+		if err != nil {
+			infos = append(infos, CertInfo{Path: path, Err: fmt.Errorf("simplecert: failed to parse %s: %w", path, err)})
+			return nil
+		}
+
+		leaf := certificates[0]
+		infos = append(infos, CertInfo{
+			Path:     path,
+			Domains:  certNames(leaf),
+			NotAfter: leaf.NotAfter,
+			DaysLeft: int(math.Floor(time.Until(leaf.NotAfter).Hours() / 24)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to scan %s: %w", root, err)
+	}
+
+	return infos, nil
+}
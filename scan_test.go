@@ -0,0 +1,103 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestScanCacheDir(t *testing.T) {
+	root := t.TempDir()
+
+	write := func(dir string, validity time.Duration) {
+		t.Helper()
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		cert := testCertResource(t, validity)
+		if err := os.WriteFile(filepath.Join(dir, certFileName), cert.Certificate, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(root, "api"), 10*24*time.Hour+time.Hour)
+	write(filepath.Join(root, "web"), 60*24*time.Hour+time.Hour)
+
+	// archived certs and backups are not reported
+	write(filepath.Join(root, "web", archiveDirName, "20240101T000000.000000000Z"), time.Hour)
+	write(filepath.Join(root, "web", "backup-2024-01-01"), time.Hour)
+
+	infos, err := ScanCacheDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 certs, got %d: %v", len(infos), infos)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	for i, want := range []struct {
+		dir      string
+		daysLeft int
+	}{
+		{"api", 10},
+		{"web", 60},
+	} {
+		info := infos[i]
+		if info.Path != filepath.Join(root, want.dir, certFileName) {
+			t.Errorf("unexpected path %s", info.Path)
+		}
+		if info.DaysLeft != want.daysLeft {
+			t.Errorf("%s: expected %d days left, got %d", want.dir, want.daysLeft, info.DaysLeft)
+		}
+		if len(info.Domains) != 1 || info.Domains[0] != "example.com" {
+			t.Errorf("%s: unexpected domains %v", want.dir, info.Domains)
+		}
+	}
+}
+
+func TestScanCacheDirErrors(t *testing.T) {
+	root := t.TempDir()
+
+	expired := filepath.Join(root, "expired")
+	if err := os.MkdirAll(expired, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(expired, certFileName), testCertResource(t, -time.Hour).Certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a corrupt cert does not abort the scan
+	corrupt := filepath.Join(root, "corrupt")
+	if err := os.MkdirAll(corrupt, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(corrupt, certFileName), []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := ScanCacheDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(infos), infos)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	if infos[0].Err == nil || infos[0].Path != filepath.Join(corrupt, certFileName) {
+		t.Errorf("expected an error for the corrupt cert, got %+v", infos[0])
+	}
+
+	// expired less than a day ago
+	if infos[1].Err != nil || infos[1].DaysLeft != -1 {
+		t.Errorf("expected -1 days left for the expired cert, got %+v", infos[1])
+	}
+}
@@ -6,14 +6,14 @@
 package simplecert
 
 import (
-	"errors"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 
 	"github.com/go-acme/lego/v4/certificate"
-	"github.com/sugawarayuuta/sonnet"
 )
 
 const (
@@ -21,9 +21,11 @@ const (
 	certResourceFileName = "CertResource.json"
 	certFileName         = "cert.pem"
 	keyFileName          = "key.pem"
-)
 
-var local bool
+	// file names expected in a kubernetes.io/tls Secret
+	k8sCertFileName = "tls.crt"
+	k8sKeyFileName  = "tls.key"
+)
 
 // Init obtains a new LetsEncrypt cert for the specified domains if there is none in cacheDir
 // or loads an existing one. Certs will be auto renewed in the configured interval.
@@ -34,7 +36,21 @@ var local bool
 // 5. Save To Disk
 // 6. Kickoff Renewal Routine
 func Init(cfg *Config, cleanup func()) (*CertReloader, error) {
-	// validate config
+	if len(cfg.CertGroups) > 0 {
+		return nil, errCertGroupsInit
+	}
+
+	lockConfig()
+	defer unlockConfig()
+	return initReloader(cfg, cleanup, nil)
+}
+
+// initReloader implements Init. If reloader is not nil,
+// it is filled with the cert instead of creating a new CertReloader.
+// The caller must hold configMu.
+func initReloader(cfg *Config, cleanup func(), reloader *CertReloader) (*CertReloader, error) {
+	// apply environment overrides and validate config
+	applyEnv(cfg)
 	err := CheckConfig(cfg)
 	if err != nil {
 		return nil, err
@@ -48,40 +64,48 @@ func Init(cfg *Config, cleanup func()) (*CertReloader, error) {
 	ensureCacheDirExists(c.CacheDir)
 
 	// open logfile handle
-	logFile, err := os.OpenFile(filepath.Join(c.CacheDir, logFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0755)
+	logFile, err := openLogFile()
 	if err != nil {
-		return nil, errors.New("simplecert: failed to create logfile: " + err.Error())
+		return nil, err
 	}
 
-	// configure log pkg to log to stdout and into the logfile
-	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
-
 	if c.Local {
-		// Status() needs to know whether simplecert is running locally
-		// since there is no need to expose the entire configuration for this
-		// we will only make local accessible within simplecert
-		local = true
-
 		// update the cachedir path
 		// certs used in local mode are stored in the "local" subfolder
 		// to avoid overwriting a production certificate
-		c.CacheDir = filepath.Join(c.CacheDir, "local")
+		c.localDir = localCacheDir(c)
+		c.CacheDir = c.localDir
+	}
+
+	// the config is complete, publish it for Status
+	current.Store(c)
+
+	if c.Local {
 
 		// make sure the cacheDir/local folder exists
 		ensureCacheDirExists(c.CacheDir)
 
 		var (
-			certFilePath = filepath.Join(c.CacheDir, certFileName)
-			keyFilePath  = filepath.Join(c.CacheDir, keyFileName)
+			certFilePath = filepath.Join(c.CacheDir, c.certFile())
+			keyFilePath  = filepath.Join(c.CacheDir, c.keyFile())
 		)
 
 		// check if a local cert is already cached
 		if certCached(c.CacheDir) {
-			// cert cached! Did the domains change?
+			// cert cached! Does it match the key and did the domains change?
 			// If the domains have been modified we need to generate a new certificate
-			if domainsChanged(certFilePath, keyFilePath) {
+			if err = checkKeyPair(certFilePath, keyFilePath); err != nil {
+				log.Println("[ERROR] simplecert: cached cert and key do not match, generating a new one: ", err)
+				createLocalCert(certFilePath, keyFilePath)
+			} else if domainsChanged(certFilePath, keyFilePath) {
 				log.Println("[INFO] cert cached but domains have changed. generating a new one...")
 				createLocalCert(certFilePath, keyFilePath)
+			} else if localKeyTypeChanged(certFilePath) {
+				log.Println("[INFO] cert cached but key type has changed. generating a new one...")
+				createLocalCert(certFilePath, keyFilePath)
+			} else if localCertExpiring(certFilePath) {
+				log.Println("[INFO] cert cached but about to expire. generating a new one...")
+				createLocalCert(certFilePath, keyFilePath)
 			}
 		} else {
 			// nothing there yet. create a new one
@@ -90,25 +114,43 @@ func Init(cfg *Config, cleanup func()) (*CertReloader, error) {
 
 		// create entries in /etc/hosts if necessary
 		if c.UpdateHosts {
-			updateHosts()
+			err = updateHosts()
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		// return a cert reloader for the local cert
-		return NewCertReloader(certFilePath, keyFilePath, logFile, cleanup)
+		return startReloader(reloader, certFilePath, keyFilePath, logFile, cleanup)
 	}
 
 	var (
-		certFilePath       = filepath.Join(c.CacheDir, certFileName)
-		keyFilePath        = filepath.Join(c.CacheDir, keyFileName)
+		certFilePath       = filepath.Join(c.CacheDir, c.certFile())
+		keyFilePath        = filepath.Join(c.CacheDir, c.keyFile())
 		certDomainsChanged bool
 	)
 
+	// acquire the cacheDir lock, so only a single process sharing the cacheDir talks to the CA.
+	// if another process is currently obtaining a certificate, this blocks until it is done
+	// and the certificate it produced will be found in the cacheDir and loaded below.
+	lock, err := lockCacheDir(c.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to lock cacheDir: %w", err)
+	}
+	defer lock.unlock()
+
 	// do we have a certificate in cacheDir?
 	if certCached(c.CacheDir) {
 		/*
 		 *	Cert Found. Load it
 		 */
 
+		// a corrupted or mismatched cert and key would result in failing handshakes
+		if err = checkKeyPair(certFilePath, keyFilePath); err != nil {
+			log.Println("[ERROR] simplecert: cached cert and key do not match, obtaining a new certificate: ", err)
+			goto obtainNewCert
+		}
+
 		if domainsChanged(certFilePath, keyFilePath) {
 			log.Println("[INFO] domains have changed. Obtaining a new certificate...")
 
@@ -116,7 +158,7 @@ func Init(cfg *Config, cleanup func()) (*CertReloader, error) {
 			goto obtainNewCert
 		}
 
-		return loadStoredCert(certFilePath, keyFilePath, logFile, cleanup)
+		return loadStoredCert(reloader, certFilePath, keyFilePath, logFile, cleanup)
 	}
 
 obtainNewCert:
@@ -125,28 +167,63 @@ obtainNewCert:
 	 *	No Cert Found. Register a new one
 	 */
 
-	u, err := getUser()
-	if err != nil {
-		return nil, errors.New("simplecert: failed to get ACME user: " + err.Error())
+	// the HTTP and TLS challenges can only succeed if the domains point to this machine
+	if c.PreflightDNSCheck && !c.usesDNSChallenge() {
+		err = CheckDomainsResolve(c)
+		if err != nil {
+			return nil, err
+		}
 	}
 
+	// the ports are handed over in BeforeChallengeBind and can not be checked before
+	if c.BeforeChallengeBind == nil {
+		err = checkChallengePorts(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the ObtainTimeout includes the account registration
+	deadline := obtainDeadline()
+
 	// get ACME Client
-	client, err := createClient(u, c.DNSServers)
+	var client acmeClient
+	err = withDeadline(deadline, func() (errClient error) {
+		client, errClient = newACMEClient()
+		return errClient
+	})
 	if err != nil {
-		return nil, errors.New("simplecert: failed to create lego.Client: " + err.Error())
+		return nil, err
+	}
+
+	if c.Profile != "" {
+		checkProfile()
 	}
 
 	// bundle CA with certificate to avoid "transport: x509: certificate signed by unknown authority" error
 	request := certificate.ObtainRequest{
-		Domains: c.Domains,
-		Bundle:  true,
+		Domains:        c.asciiDomains(),
+		Bundle:         true,
+		MustStaple:     c.MustStaple,
+		PreferredChain: c.PreferredChain,
+		Profile:        c.Profile,
 	}
 
+	logEvent(slog.LevelInfo, "obtaining cert", "domains", c.Domains)
+
 	// Obtain a new certificate
 	// The acme library takes care of completing the challenges to obtain the certificate(s).
 	// The domains must resolve to this machine or you have to use the DNS challenge.
-	cert, err := client.Certificate.Obtain(request)
+	var cert *certificate.Resource
+	err = withDeadline(deadline, func() error {
+		return withChallengeServers("obtaining cert", func() (errObtain error) {
+			cert, errObtain = client.Obtain(request)
+			return errObtain
+		})
+	})
 	if err != nil {
+		reportError("obtain", err)
+
 		// check if we tried to obtain a new cert because the domains changed compared to a cached cert
 		if certDomainsChanged {
 			// if yes, log an error that this obtaining the cert failed
@@ -154,28 +231,73 @@ obtainNewCert:
 
 			// but init with the previously cached certificate
 			log.Println("[INFO] simplecert: loading cached certificate from disk")
-			return loadStoredCert(certFilePath, keyFilePath, logFile, cleanup)
+			return loadStoredCert(reloader, certFilePath, keyFilePath, logFile, cleanup)
 		}
-		return nil, errors.New("simplecert: failed to obtain cert: " + err.Error())
+		return nil, fmt.Errorf("%w: %w", ErrObtainFailed, err)
 	}
 
-	log.Println("[INFO] simplecert: client obtained cert for domain: ", cert.Domain)
+	logEvent(slog.LevelInfo, "obtained cert", "domains", c.Domains, "not_after", certNotAfter(cert))
+	checkPreferredChain(cert)
 
 	// Save cert to disk
 	err = saveCertToDisk(cert, c.CacheDir)
 	if err != nil {
-		return nil, errors.New("simplecert: failed to write cert to disk: " + err.Error())
+		reportError("save", err)
+		return nil, fmt.Errorf("simplecert: failed to write cert to disk: %w", err)
 	}
 
 	log.Println("[INFO] simplecert: wrote new cert to disk!")
 
+	notifyCTLogs(cert)
+	notifyCertChanged(cert)
+
+	certReloader, err := startReloader(reloader, certFilePath, keyFilePath, logFile, cleanup)
+	if err != nil {
+		return nil, err
+	}
+
 	// kickoff renewal routine
-	go renewalRoutine(cert)
+	startRenewalRoutine(cert, certReloader)
 
-	return NewCertReloader(certFilePath, keyFilePath, logFile, cleanup)
+	return certReloader, nil
+}
+
+// openLogFile opens the configured LogFile and writes the log into it, in addition to stdout
+// if a LogWriter or no LogFile is configured, nil is returned
+func openLogFile() (*os.File, error) {
+	if c.LogWriter != nil {
+		log.SetOutput(io.MultiWriter(os.Stdout, c.LogWriter))
+		return nil, nil
+	}
+	if c.LogFile == "" {
+		return nil, nil
+	}
+
+	// relative paths are placed inside the cacheDir
+	path := c.LogFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.CacheDir, path)
+	}
+
+	logFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to create logfile: %w", err)
+	}
+
+	// logfiles created by previous versions are world readable
+	err = logFile.Chmod(0600)
+	if err != nil {
+		logFile.Close()
+		return nil, fmt.Errorf("simplecert: failed to set logfile permissions: %w", err)
+	}
+
+	log.SetOutput(io.MultiWriter(os.Stdout, newLogWriter(logFile)))
+
+	return logFile, nil
 }
 
 func loadStoredCert(
+	reloader *CertReloader,
 	certFilePath string,
 	keyFilePath string,
 	logFile *os.File,
@@ -184,42 +306,44 @@ func loadStoredCert(
 	log.Println("[INFO] simplecert: found cert in cacheDir")
 
 	// read cert resource from disk
-	b, err := os.ReadFile(filepath.Join(c.CacheDir, certResourceFileName))
+	cert, err := readCertResource(c, filepath.Join(c.CacheDir, c.certResourceFile()))
+	if err != nil && keyFilePath == filepath.Join(c.CacheDir, c.keyFile()) {
+		// the cert and key have been checked already, a corrupt resource file can be restored from them
+		log.Println("[WARNING] simplecert:", err, "- restoring it from", certFilePath, "and", keyFilePath)
+		cert, err = restoreCertResource(certFilePath, keyFilePath, c.CacheDir)
+	}
 	if err != nil {
-		return nil, errors.New("simplecert: failed to read CertResource.json from disk: " + err.Error())
+		return nil, fmt.Errorf("%w: %w", ErrLoadFailed, err)
 	}
 
-	// unmarshal certificate resource
-	var cr CR
-	err = sonnet.Unmarshal(b, &cr)
+	// CertReloader must be created before starting the renewal check
+	// since a renewal might result in receiving the reload signal for triggering the reload
+	// the goroutine for handling the signal and taking action is started when creating the reloader
+	certReloader, err := startReloader(reloader, certFilePath, keyFilePath, logFile, cleanup)
 	if err != nil {
-		return nil, errors.New("simplecert: failed to unmarshal certificate resource: " + err.Error())
+		return nil, fmt.Errorf("%w: %w", ErrLoadFailed, err)
 	}
 
-	var (
-		// CertReloader must be created before starting the renewal check
-		// since a renewal might result in receiving a SIGHUP for triggering the reload
-		// the goroutine for handling the signal and taking action is started when creating the reloader
-		certReloader, errReloader = NewCertReloader(certFilePath, keyFilePath, logFile, cleanup)
-		cert                      = getACMECertResource(cr)
-	)
-
 	// renew cert if necessary
-	errRenew := renew(cert)
+	// the cacheDir lock is held by Init at this point
+	errRenew := renew(cert, certReloader)
 	if errRenew != nil {
+		reportError("renew", errRenew)
+
 		// call handler if set
-		if c.FailedToRenewCertificate != nil {
-			// invoke the user's handler
-			c.FailedToRenewCertificate(errRenew)
+		if failed := c.FailedToRenewCertificate; failed != nil {
+			// invoke the user's handler, without holding configMu
+			withoutConfigLock(func() { failed(errRenew) })
 
 			// if a handler was called keep running and init normally
 		} else {
-			return nil, errors.New("simplecert: failed to renew cached cert on startup and no failedToRenewCert handler is configured: " + errRenew.Error())
+			certReloader.Close()
+			return nil, fmt.Errorf("simplecert: failed to renew cached cert on startup and no failedToRenewCert handler is configured: %w", errRenew)
 		}
 	}
 
 	// kickoff renewal routine
-	go renewalRoutine(cert)
+	startRenewalRoutine(cert, certReloader)
 
-	return certReloader, errReloader
+	return certReloader, nil
 }
@@ -0,0 +1,530 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+
+// Package simplecerttest provides an in-memory ACME server
+// for testing simplecert integrations without network access or a real CA.
+//
+// The server implements the subset of RFC 8555 used by simplecert.
+// Authorizations are valid right away, so no challenge needs to be solved,
+// and certificates are issued by a test root CA, see Server.RootCAs.
+package simplecerttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/3JoB/simplecert"
+	"github.com/sugawarayuuta/sonnet"
+)
+
+// default validity of the issued certificates
+const defaultValidity = 90 * 24 * time.Hour
+
+// Server is an in-memory ACME server
+type Server struct {
+	// Validity of the issued certificates, defaults to 90 days.
+	// Use a short validity together with Config.RenewBefore to test renewals.
+	Validity time.Duration
+
+	srv    *httptest.Server
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  []byte
+
+	mu       sync.Mutex
+	nextID   int
+	orders   map[string]*order
+	certs    map[string][]byte
+	revoked  map[string]bool
+	accounts map[string]bool
+	issued   int
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+}
+
+// NewServer starts an ACME server, which is closed when the test finishes
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+
+	s := &Server{
+		Validity: defaultValidity,
+		orders:   make(map[string]*order),
+		certs:    make(map[string][]byte),
+		revoked:  make(map[string]bool),
+		accounts: make(map[string]bool),
+	}
+
+	err := s.createCA()
+	if err != nil {
+		tb.Fatal("simplecerttest: failed to create CA: ", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.handleDirectory)
+	mux.HandleFunc("/nonce", s.handleNonce)
+	mux.HandleFunc("POST /account", s.handleNewAccount)
+	mux.HandleFunc("POST /account/{id}", s.handleAccount)
+	mux.HandleFunc("POST /order", s.handleNewOrder)
+	mux.HandleFunc("POST /order/{id}", s.handleOrder)
+	mux.HandleFunc("POST /authz/{order}/{index}", s.handleAuthz)
+	mux.HandleFunc("POST /finalize/{id}", s.handleFinalize)
+	mux.HandleFunc("POST /cert/{id}", s.handleCert)
+	mux.HandleFunc("POST /revoke", s.handleRevoke)
+
+	s.srv = httptest.NewTLSServer(mux)
+	tb.Cleanup(s.srv.Close)
+
+	return s
+}
+
+// DirectoryURL returns the URL of the ACME directory
+func (s *Server) DirectoryURL() string {
+	return s.srv.URL + "/directory"
+}
+
+// Client returns a HTTP client trusting the TLS certificate of the ACME server
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// TrustingClient returns a HTTP client trusting the certificates issued by the server,
+// to send requests to a server using a certificate obtained in the test
+func (s *Server) TrustingClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: s.RootCAs(),
+			},
+		},
+	}
+}
+
+// RootCAs returns a pool containing the root CA that signs the issued certificates
+func (s *Server) RootCAs() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.caCert)
+	return pool
+}
+
+// Issued returns the number of certificates issued so far
+func (s *Server) Issued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+// Accounts returns the number of accounts registered so far
+func (s *Server) Accounts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.accounts)
+}
+
+// Revoked reports whether cert has been revoked
+func (s *Server) Revoked(cert *x509.Certificate) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.revoked[cert.SerialNumber.String()]
+}
+
+// Config returns a configuration for the domains pointing at the server,
+// with a temporary CacheDir and the HTTP challenge handler enabled,
+// since the server does not validate any challenges.
+func (s *Server) Config(tb testing.TB, domains ...string) *simplecert.Config {
+	tb.Helper()
+
+	cfg := *simplecert.Default
+	cfg.DirectoryURL = s.DirectoryURL()
+	cfg.HTTPClient = s.Client()
+	cfg.SSLEmail = "test@example.com"
+	cfg.Domains = domains
+	cfg.CacheDir = tb.TempDir()
+	cfg.HTTPAddress = ""
+	cfg.TLSAddress = ""
+	cfg.HTTPChallengeHandler = true
+	cfg.UpdateHosts = false
+	cfg.ObtainRetries = 0
+
+	return &cfg
+}
+
+/*
+ *	Handlers
+ */
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"newNonce":   s.srv.URL + "/nonce",
+		"newAccount": s.srv.URL + "/account",
+		"newOrder":   s.srv.URL + "/order",
+		"revokeCert": s.srv.URL + "/revoke",
+		"keyChange":  s.srv.URL + "/key-change",
+		"meta": map[string]interface{}{
+			"termsOfService": s.srv.URL + "/terms",
+		},
+	})
+}
+
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	s.setNonce(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Contact []string `json:"contact"`
+	}
+	if err := readJWS(r, &req); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	id := s.newID()
+	s.mu.Lock()
+	s.accounts[id] = true
+	s.mu.Unlock()
+
+	w.Header().Set("Location", s.srv.URL+"/account/"+id)
+	s.writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"status":  "valid",
+		"contact": req.Contact,
+	})
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	ok := s.accounts[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown account")
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "valid",
+	})
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if err := readJWS(r, &req); err != nil || len(req.Identifiers) == 0 {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", "invalid order")
+		return
+	}
+
+	id := s.newID()
+	o := &order{
+		Status:      "ready",
+		Expires:     time.Now().Add(time.Hour).Format(time.RFC3339),
+		Identifiers: req.Identifiers,
+		Finalize:    s.srv.URL + "/finalize/" + id,
+	}
+	for i := range req.Identifiers {
+		o.Authorizations = append(o.Authorizations, s.srv.URL+"/authz/"+id+"/"+strconv.Itoa(i))
+	}
+
+	s.mu.Lock()
+	s.orders[id] = o
+	s.mu.Unlock()
+
+	w.Header().Set("Location", s.srv.URL+"/order/"+id)
+	s.writeJSON(w, http.StatusCreated, o)
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request) {
+	o, ok := s.order(r.PathValue("id"))
+	if !ok {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, o)
+}
+
+// handleAuthz returns a valid authorization, so no challenge needs to be solved
+func (s *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.Atoi(r.PathValue("index"))
+	o, ok := s.order(r.PathValue("order"))
+	if err != nil || !ok || index >= len(o.Identifiers) {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+
+	ident := o.Identifiers[index]
+	wildcard := len(ident.Value) > 2 && ident.Value[:2] == "*."
+	if wildcard {
+		ident.Value = ident.Value[2:]
+	}
+
+	s.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "valid",
+		"expires":    o.Expires,
+		"identifier": ident,
+		"wildcard":   wildcard,
+		"challenges": []map[string]string{{
+			"type":   "http-01",
+			"url":    s.srv.URL + r.URL.Path,
+			"status": "valid",
+			"token":  "token",
+		}},
+	})
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	o, ok := s.order(id)
+	if !ok {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := readJWS(r, &req); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+
+	chain, err := s.issue(csr)
+	if err != nil {
+		s.writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.certs[id] = chain
+	s.issued++
+	o.Status = "valid"
+	o.Certificate = s.srv.URL + "/cert/" + id
+	s.mu.Unlock()
+
+	w.Header().Set("Location", s.srv.URL+"/order/"+id)
+	s.writeJSON(w, http.StatusOK, o)
+}
+
+func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	chain, ok := s.certs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		s.writeProblem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+
+	s.setNonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+func (s *Server) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Certificate string `json:"certificate"`
+	}
+	if err := readJWS(r, &req); err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.Certificate)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		s.writeProblem(w, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.revoked[cert.SerialNumber.String()] {
+		s.writeProblem(w, http.StatusBadRequest, "alreadyRevoked", "certificate already revoked")
+		return
+	}
+	s.revoked[cert.SerialNumber.String()] = true
+
+	s.setNonce(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+ *	Utils
+ */
+
+// createCA creates the root CA signing the issued certificates
+func (s *Server) createCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "simplecerttest root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+
+	s.caCert, err = x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+	s.caKey = key
+	s.caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return nil
+}
+
+// issue signs a certificate for the CSR and returns the PEM encoded chain
+func (s *Server) issue(csr *x509.CertificateRequest) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: csr.Subject.CommonName},
+		DNSNames:        csr.DNSNames,
+		IPAddresses:     csr.IPAddresses,
+		NotBefore:       time.Now().Add(-time.Minute),
+		NotAfter:        time.Now().Add(s.Validity),
+		KeyUsage:        keyUsage,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: csr.Extensions,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), s.caPEM...), nil
+}
+
+func (s *Server) order(id string) (*order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	return o, ok
+}
+
+func (s *Server) newID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+// setNonce adds a fresh nonce to the response, nonces are not validated
+func (s *Server) setNonce(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", "nonce-"+s.newID())
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := sonnet.Marshal(v)
+	if err != nil {
+		s.writeProblem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+
+	s.setNonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func (s *Server) writeProblem(w http.ResponseWriter, status int, typ, detail string) {
+	b, _ := sonnet.Marshal(map[string]interface{}{
+		"type":   "urn:ietf:params:acme:error:" + typ,
+		"detail": detail,
+		"status": status,
+	})
+
+	s.setNonce(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// readJWS decodes the payload of the flattened JWS in the request body into v,
+// the signature is not verified. The payload of POST-as-GET requests is empty.
+func readJWS(r *http.Request, v interface{}) error {
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	err = sonnet.Unmarshal(body, &jws)
+	if err != nil {
+		return err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return err
+	}
+	if len(payload) == 0 {
+		return nil
+	}
+
+	return sonnet.Unmarshal(payload, v)
+}
@@ -0,0 +1,583 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+
+package simplecerttest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/3JoB/simplecert"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+func TestObtainCertificate(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com", "www.example.com")
+	cfg.DisableReloadSignal = true
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	tlsCert, err := reloader.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName: "www.example.com",
+		Roots:   srv.RootCAs(),
+	})
+	if err != nil {
+		t.Fatal("certificate not trusted by test root: ", err)
+	}
+	if srv.Issued() != 1 {
+		t.Fatalf("expected 1 issued certificate, got %d", srv.Issued())
+	}
+
+	certPEM, err := reloader.CertPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := reloader.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal("CertPEM and KeyPEM do not form a valid pair: ", err)
+	}
+
+	if !reloader.NotAfter().Equal(leaf.NotAfter) {
+		t.Errorf("expected NotAfter %s, got %s", leaf.NotAfter, reloader.NotAfter())
+	}
+	if next := reloader.NextRenewal(); next.IsZero() || next.After(leaf.NotAfter) {
+		t.Errorf("unexpected next renewal %s for cert expiring at %s", next, leaf.NotAfter)
+	}
+}
+
+func TestInitWithFallback(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+
+	reloader, done, err := simplecert.InitWithFallback(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	leaf := func() *x509.Certificate {
+		t.Helper()
+		tlsCert, err := reloader.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: "example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf
+	}
+
+	// the fallback cert is served right away, whether or not Init is done yet
+	if leaf().VerifyHostname("example.com") != nil {
+		t.Fatal("fallback cert does not cover example.com")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = leaf().Verify(x509.VerifyOptions{
+		DNSName: "example.com",
+		Roots:   srv.RootCAs(),
+	})
+	if err != nil {
+		t.Fatal("expected the issued cert after Init completed: ", err)
+	}
+	if reloader.NextRenewal().IsZero() {
+		t.Error("expected the renewal routine to be running")
+	}
+}
+
+func TestInitGroups(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t)
+	cfg.DisableReloadSignal = true
+	cfg.CertGroups = [][]string{
+		{"internal.example.com"},
+		{"example.com", "www.example.com"},
+	}
+
+	if _, err := simplecert.Init(cfg, nil); err == nil {
+		t.Fatal("expected Init to reject CertGroups")
+	}
+
+	reloaders, err := simplecert.InitGroups(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reloaders {
+		defer r.Close()
+	}
+
+	if len(reloaders) != 2 {
+		t.Fatalf("expected 2 reloaders, got %d", len(reloaders))
+	}
+	if srv.Issued() != 2 {
+		t.Fatalf("expected 2 issued certificates, got %d", srv.Issued())
+	}
+
+	for i, group := range cfg.CertGroups {
+		tlsCert, err := reloaders[i].GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: group[0]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leaf.DNSNames) != len(group) {
+			t.Errorf("group %d: expected domains %v, got %v", i, group, leaf.DNSNames)
+		}
+		if reloaders[i].NextRenewal().IsZero() {
+			t.Errorf("group %d: expected the renewal routine to be running", i)
+		}
+	}
+
+	infos, err := simplecert.ScanCacheDir(cfg.CacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 certs in the cacheDir, got %d", len(infos))
+	}
+	if filepath.Dir(infos[0].Path) == filepath.Dir(infos[1].Path) {
+		t.Error("expected the certs of the groups in separate folders")
+	}
+
+	// a restart loads the cached certs
+	for _, r := range reloaders {
+		r.Close()
+	}
+	reloaders, err = simplecert.InitGroups(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reloaders {
+		defer r.Close()
+	}
+	if srv.Issued() != 2 {
+		t.Fatalf("expected the cached certs to be loaded, got %d issued certificates", srv.Issued())
+	}
+}
+
+func TestConcurrentInit(t *testing.T) {
+	domains := []string{"a.example.com", "b.example.com"}
+
+	var (
+		wg        sync.WaitGroup
+		reloaders = make([]*simplecert.CertReloader, len(domains))
+		errs      = make([]error, len(domains))
+		done      = make(chan struct{})
+	)
+	for i, domain := range domains {
+		cfg := NewServer(t).Config(t, domain)
+		cfg.DisableReloadSignal = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reloaders[i], errs[i] = simplecert.Init(cfg, nil)
+		}()
+	}
+
+	// Status can be called while the configs are swapped
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				simplecert.Status()
+			}
+		}
+	}()
+	wg.Wait()
+	close(done)
+
+	for i, domain := range domains {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		defer reloaders[i].Close()
+
+		tlsCert, err := reloaders[i].GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: domain})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != domain {
+			t.Errorf("expected a cert for %s, got %v", domain, leaf.DNSNames)
+		}
+	}
+}
+
+func TestConfigureClient(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+
+	errConfigure := errors.New("unsupported setting")
+	cfg.ConfigureClient = func(client *lego.Client) error {
+		if _, err := client.Registration.QueryRegistration(); err != nil {
+			t.Error("expected the account to be registered before ConfigureClient: ", err)
+		}
+		return errConfigure
+	}
+	_, err := simplecert.Init(cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), errConfigure.Error()) {
+		t.Fatalf("expected the error of ConfigureClient, got %v", err)
+	}
+	if srv.Issued() != 0 {
+		t.Fatalf("expected no certificate to be issued, got %d", srv.Issued())
+	}
+
+	var called int
+	cfg.ConfigureClient = func(client *lego.Client) error {
+		called++
+		return nil
+	}
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if called != 1 {
+		t.Fatalf("expected ConfigureClient to be called once, got %d", called)
+	}
+}
+
+func TestInitGroupsSplitDomains(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "a.example.com", "b.example.com", "c.example.com", "d.example.com", "e.example.com")
+	cfg.DisableReloadSignal = true
+	cfg.MaxDomainsPerCert = 2
+
+	var tooMany *simplecert.ErrTooManyDomains
+	if _, err := simplecert.Init(cfg, nil); !errors.As(err, &tooMany) {
+		t.Fatalf("expected Init to return ErrTooManyDomains, got %v", err)
+	}
+
+	reloaders, err := simplecert.InitGroups(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reloaders {
+		defer r.Close()
+	}
+
+	if len(reloaders) != 3 || srv.Issued() != 3 {
+		t.Fatalf("expected 3 certs, got %d reloaders and %d issued certificates", len(reloaders), srv.Issued())
+	}
+	for _, d := range cfg.Domains {
+		var served bool
+		for _, r := range reloaders {
+			tlsCert, err := r.GetCertificateFunc()(&tls.ClientHelloInfo{ServerName: d})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(tlsCert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			served = served || leaf.VerifyHostname(d) == nil
+		}
+		if !served {
+			t.Errorf("no cert for %s", d)
+		}
+	}
+}
+
+func TestInitFromResources(t *testing.T) {
+	srv := NewServer(t)
+
+	// the image build obtains the account and cert
+	build := srv.Config(t, "example.com")
+	build.DisableReloadSignal = true
+	reloader, err := simplecert.Init(build, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Close()
+
+	account, err := simplecert.ExportAccount(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(build.CacheDir, "CertResource.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cr simplecert.CR
+	if err := json.Unmarshal(data, &cr); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deployment starts with an empty cacheDir
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+	reloader, err = simplecert.InitFromResources(cfg, account, &cr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if srv.Issued() != 1 || srv.Accounts() != 1 {
+		t.Fatalf("expected the supplied account and cert to be used, got %d accounts and %d issued certificates", srv.Accounts(), srv.Issued())
+	}
+	served, err := reloader.CertPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(cr.Certificate), string(served)) {
+		t.Error("expected the supplied cert to be served")
+	}
+	for _, name := range []string{"cert.pem", "key.pem", "CertResource.json", "SSLUser.json"} {
+		if _, err := os.Stat(filepath.Join(cfg.CacheDir, name)); err != nil {
+			t.Errorf("expected %s in the cacheDir: %v", name, err)
+		}
+	}
+}
+
+func TestCorruptCertResource(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Close()
+
+	// truncate the resource file, the cert and key are intact
+	resource := filepath.Join(cfg.CacheDir, "CertResource.json")
+	if err := os.WriteFile(resource, []byte(`{"domain": "exa`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloader, err = simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if srv.Issued() != 1 {
+		t.Fatalf("expected the cached cert to be used, got %d issued certificates", srv.Issued())
+	}
+	if needs, _, err := simplecert.NeedsRenewal(cfg); err != nil || needs {
+		t.Fatalf("expected a readable resource file for a fresh cert, got %v, %v", needs, err)
+	}
+}
+
+func TestOnCertChanged(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+
+	var cert, key []byte
+	cfg.OnCertChanged = func(c, k []byte) error {
+		cert, key = c, k
+		return nil
+	}
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if len(cert) == 0 || len(key) == 0 {
+		t.Fatal("expected OnCertChanged to be called with the obtained cert and key")
+	}
+}
+
+func TestTrustingClient(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "localhost")
+	cfg.DisableReloadSignal = true
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = &tls.Config{GetCertificate: reloader.GetCertificateFunc()}
+	ts.StartTLS()
+	defer ts.Close()
+
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	resp, err := srv.TrustingClient().Get("https://localhost:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestTermsOfServiceURL(t *testing.T) {
+	srv := NewServer(t)
+
+	url, err := simplecert.TermsOfServiceURL(srv.Config(t, "example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "https://") {
+		t.Fatalf("unexpected terms of service URL %q", url)
+	}
+}
+
+func TestAccountStorageDir(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+	cfg.AccountStorageDir = t.TempDir()
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if _, err := os.Stat(filepath.Join(cfg.AccountStorageDir, "SSLUser.json")); err != nil {
+		t.Fatal("account not stored in AccountStorageDir: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.CacheDir, "SSLUser.json")); err == nil {
+		t.Fatal("account stored in CacheDir")
+	}
+}
+
+func TestAccountURI(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Close()
+
+	// another service has the key of the account, but no registration
+	b, err := os.ReadFile(filepath.Join(cfg.CacheDir, "SSLUser.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u simplecert.SSLUser
+	if err := json.Unmarshal(b, &u); err != nil {
+		t.Fatal(err)
+	}
+	uri := u.Registration.URI
+	u.Registration = nil
+
+	shared := srv.Config(t, "www.example.com")
+	shared.DisableReloadSignal = true
+	shared.AccountURI = uri
+	if b, err = json.Marshal(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(shared.CacheDir, "SSLUser.json"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloader, err = simplecert.Init(shared, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Close()
+
+	if srv.Accounts() != 1 {
+		t.Fatalf("expected the account to be reused, got %d accounts", srv.Accounts())
+	}
+	data, err := simplecert.ExportAccount(shared)
+	if err != nil {
+		t.Fatal("expected the queried registration to be stored: ", err)
+	}
+	if !strings.Contains(string(data), uri) {
+		t.Errorf("expected the stored registration to use %s", uri)
+	}
+
+	// an unknown account is not replaced by a new registration
+	unknown := srv.Config(t, "example.com")
+	unknown.DisableReloadSignal = true
+	unknown.AccountURI = uri + "0"
+	if _, err := simplecert.Init(unknown, nil); err == nil {
+		t.Fatal("expected Init to fail for an unknown account")
+	}
+	if srv.Accounts() != 1 {
+		t.Fatalf("expected no new registration, got %d accounts", srv.Accounts())
+	}
+}
+
+func TestFileNames(t *testing.T) {
+	srv := NewServer(t)
+	cfg := srv.Config(t, "example.com")
+	cfg.DisableReloadSignal = true
+	cfg.CertFileName = "tls.crt"
+	cfg.KeyFileName = "tls.key"
+	cfg.CertResourceFileName = "resource.json"
+
+	reloader, err := simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloader.Close()
+
+	for _, name := range []string{"tls.crt", "tls.key", "resource.json"} {
+		if _, err := os.Stat(filepath.Join(cfg.CacheDir, name)); err != nil {
+			t.Errorf("expected %s in the cacheDir: %v", name, err)
+		}
+	}
+	for _, name := range []string{"cert.pem", "key.pem", "CertResource.json"} {
+		if _, err := os.Stat(filepath.Join(cfg.CacheDir, name)); err == nil {
+			t.Errorf("unexpected %s in the cacheDir", name)
+		}
+	}
+
+	// the cached cert is loaded from the configured files
+	reloader, err = simplecert.Init(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	if srv.Issued() != 1 {
+		t.Fatalf("expected the cached cert to be loaded, got %d issued certificates", srv.Issued())
+	}
+}
@@ -20,16 +20,19 @@ type CertStatus struct {
 // the actual error message will never be passed to the caller and only appear in the simplecert logs
 // therefore always check if you received a result != nil when calling Status()
 func Status() *CertStatus {
-	var certData []byte
-	if !local {
-		// prevent a nil pointer exception if the status API is called
-		// but the config hasn't been initialized yet
-		if c == nil {
-			return nil
-		}
+	// read the published config, Status may be called from a hook during a renewal
+	c := current.Load()
+
+	// prevent a nil pointer exception if the status API is called
+	// but the config hasn't been initialized yet
+	if c == nil {
+		return nil
+	}
 
+	var certData []byte
+	if !c.Local {
 		// read cert resource from disk
-		b, err := os.ReadFile(filepath.Join(c.CacheDir, certResourceFileName))
+		b, err := os.ReadFile(filepath.Join(c.CacheDir, c.certResourceFile()))
 		if err != nil {
 			fmt.Println("[Status] simplecert: failed to read CertResource.json from disk: ", err)
 			return nil
@@ -48,7 +51,7 @@ func Status() *CertStatus {
 	} else {
 		// read local cert data from disk
 		var err error
-		certData, err = os.ReadFile(filepath.Join(c.CacheDir, "cert.pem"))
+		certData, err = os.ReadFile(filepath.Join(c.CacheDir, c.certFile()))
 		if err != nil {
 			fmt.Println("[Status] simplecert: failed to read cert.pem from disk: ", err)
 			return nil
@@ -80,6 +83,42 @@ func Status() *CertStatus {
 	return &CertStatus{
 		Domains:     x509Cert.DNSNames,
 		Expires:     int(timeLeft.Hours()),
-		RenewBefore: c.RenewBefore,
+		RenewBefore: int(c.renewBefore().Hours()),
+	}
+}
+
+// NeedsRenewal reports whether the cert cached for cfg is inside the renewal window, and when it expires.
+// It only reads the cacheDir and can be called before Init, e.g. to decide whether to run a maintenance window.
+// In local mode, the window in which local certs are regenerated is used.
+// ARI is not queried, the renewal window is computed from RenewBefore and MaxClockSkew, or decided by ShouldRenew if it is set.
+func NeedsRenewal(cfg *Config) (bool, time.Time, error) {
+	// do not modify the passed config
+	conf := *cfg
+	applyEnv(&conf)
+
+	// only the cert is read, the key might be encrypted with a passphrase that is not at hand yet
+	var (
+		dir    = conf.CacheDir
+		window = conf.renewBefore() + conf.MaxClockSkew
+	)
+	if conf.Local {
+		dir = localCacheDir(&conf)
+		window = localRenewBefore
+	}
+
+	certData, err := os.ReadFile(filepath.Join(dir, conf.certFile()))
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("simplecert: failed to read cached cert: %w", err)
+	}
+
+	certificates, err := parsePEMBundle(certData)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("simplecert: failed to parsePEMBundle: %w", err)
+	}
+
+	notAfter := certificates[0].NotAfter
+	if conf.ShouldRenew != nil && !conf.Local {
+		return conf.ShouldRenew(certificates[0]), notAfter, nil
 	}
+	return time.Until(notAfter) <= window, notAfter, nil
 }
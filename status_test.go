@@ -0,0 +1,101 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNeedsRenewal(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		validity time.Duration
+		skew     time.Duration
+		want     bool
+	}{
+		{"outside window", 60 * 24 * time.Hour, 0, false},
+		{"inside window", 10 * 24 * time.Hour, 0, true},
+		{"inside skew window", 32 * 24 * time.Hour, 3 * 24 * time.Hour, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setupRenewTest(t, &fakeClient{t: t})
+			c.MaxClockSkew = tt.skew
+
+			cert := testCertResource(t, tt.validity)
+			if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+				t.Fatal(err)
+			}
+
+			needsRenewal, notAfter, err := NeedsRenewal(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if needsRenewal != tt.want {
+				t.Errorf("NeedsRenewal() = %v, want %v", needsRenewal, tt.want)
+			}
+			if d := time.Until(notAfter) - tt.validity; d > time.Minute || d < -time.Minute {
+				t.Errorf("unexpected expiry %s", notAfter)
+			}
+		})
+	}
+}
+
+func TestNeedsRenewalNoCert(t *testing.T) {
+	setupRenewTest(t, &fakeClient{t: t})
+
+	if _, _, err := NeedsRenewal(c); err == nil {
+		t.Fatal("expected an error without a cached cert")
+	}
+}
+
+func TestNeedsRenewalEncryptedKey(t *testing.T) {
+	setupRenewTest(t, &fakeClient{t: t})
+	c.KeyEncryptionPassphrase = []byte("secret")
+
+	cert := testCertResource(t, 10*24*time.Hour)
+	if err := saveCertToDisk(cert, c.CacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// called before Init, without the passphrase
+	cfg := *c
+	cfg.KeyEncryptionPassphrase = nil
+	setConfig(nil)
+
+	needsRenewal, _, err := NeedsRenewal(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !needsRenewal {
+		t.Error("expected the cert to need a renewal")
+	}
+}
+
+func TestNeedsRenewalLocal(t *testing.T) {
+	cfg := &Config{CacheDir: t.TempDir(), Local: true}
+
+	dir := filepath.Join(cfg.CacheDir, "local")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCert(t, dir, "example.com")
+
+	// before Init, the local subfolder of the CacheDir is read
+	if needsRenewal, _, err := NeedsRenewal(cfg); err != nil || !needsRenewal {
+		t.Fatalf("NeedsRenewal() = %v, %v before Init", needsRenewal, err)
+	}
+
+	// Init moves the CacheDir into the local subfolder, copies of the config must not append it again
+	cfg.localDir = localCacheDir(cfg)
+	cfg.CacheDir = cfg.localDir
+	conf := *cfg
+	if needsRenewal, _, err := NeedsRenewal(&conf); err != nil || !needsRenewal {
+		t.Fatalf("NeedsRenewal() = %v, %v after Init", needsRenewal, err)
+	}
+}
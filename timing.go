@@ -0,0 +1,142 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// ChallengeTimings break down the time spent obtaining or renewing a cert
+type ChallengeTimings struct {
+	// Action is "obtaining cert", "obtaining cert for CSR" or "renewing cert"
+	Action  string
+	Domains []string
+
+	// Challenge is the time from presenting the first challenge until the last one has been cleaned up,
+	// including the DNS propagation check. It is zero if the CA had valid authorizations already.
+	Challenge time.Duration
+
+	// Finalize is the time from the end of the challenges until the cert has been issued
+	Finalize time.Duration
+
+	// Total is the time of the successful attempt, including creating the order
+	Total time.Duration
+}
+
+// challengeTimer records when the challenges of an order are presented and cleaned up.
+// Obtains are serialized by configMu, so a single timer is sufficient.
+var challengeTimer solveTimer
+
+type solveTimer struct {
+	sync.Mutex
+	presented time.Time
+	cleanedUp time.Time
+}
+
+func (t *solveTimer) reset() {
+	t.Lock()
+	defer t.Unlock()
+	t.presented, t.cleanedUp = time.Time{}, time.Time{}
+}
+
+func (t *solveTimer) present() {
+	t.Lock()
+	defer t.Unlock()
+	if t.presented.IsZero() {
+		t.presented = time.Now()
+	}
+}
+
+func (t *solveTimer) cleanUp() {
+	t.Lock()
+	defer t.Unlock()
+	t.cleanedUp = time.Now()
+}
+
+// timings returns the timings of an attempt that started at start and completed now
+func (t *solveTimer) timings(action string, start time.Time) ChallengeTimings {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	timings := ChallengeTimings{
+		Action:  action,
+		Domains: c.Domains,
+		Total:   now.Sub(start),
+	}
+	timings.Finalize = timings.Total
+	if !t.presented.IsZero() && !t.cleanedUp.IsZero() {
+		timings.Challenge = t.cleanedUp.Sub(t.presented)
+		timings.Finalize = now.Sub(t.cleanedUp)
+	}
+	return timings
+}
+
+// timedProvider records the challenges solved by the wrapped provider in the challengeTimer
+type timedProvider struct {
+	challenge.Provider
+}
+
+// sequentialTimedProvider is a timedProvider wrapping a sequential DNS provider
+type sequentialTimedProvider struct {
+	timedProvider
+}
+
+func (p sequentialTimedProvider) Sequential() time.Duration {
+	return p.Provider.(sequential).Sequential()
+}
+
+// timeChallenges wraps p to record the time spent solving the challenges
+func timeChallenges(p challenge.Provider) challenge.Provider {
+	if _, ok := p.(sequential); ok {
+		return sequentialTimedProvider{timedProvider{Provider: p}}
+	}
+	return timedProvider{Provider: p}
+}
+
+func (p timedProvider) Present(domain, token, keyAuth string) error {
+	challengeTimer.present()
+	return p.Provider.Present(domain, token, keyAuth)
+}
+
+func (p timedProvider) CleanUp(domain, token, keyAuth string) error {
+	defer challengeTimer.cleanUp()
+	return p.Provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout passes the propagation timeout of a DNS provider through the wrapper
+func (p timedProvider) Timeout() (timeout, interval time.Duration) {
+	if pt, ok := p.Provider.(challenge.ProviderTimeout); ok {
+		return pt.Timeout()
+	}
+	return dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+}
+
+// withChallengeTimings runs fn and reports the timings of the challenges it solved, if it succeeds
+func withChallengeTimings(action string, fn func() error) func() error {
+	return func() error {
+		challengeTimer.reset()
+		start := time.Now()
+
+		err := fn()
+		if err != nil {
+			return err
+		}
+
+		timings := challengeTimer.timings(action, start)
+		logEvent(slog.LevelInfo, "challenge timings", "action", action, "domains", timings.Domains,
+			"challenge", timings.Challenge, "finalize", timings.Finalize, "total", timings.Total)
+		if c.OnChallengeTimings != nil {
+			c.OnChallengeTimings(timings)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,58 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeTimings(t *testing.T) {
+	var timings []ChallengeTimings
+	setConfig(&Config{
+		Domains:            []string{"example.com"},
+		OnChallengeTimings: func(t ChallengeTimings) { timings = append(timings, t) },
+	})
+	defer setConfig(nil)
+
+	p := timeChallenges(&manualDNSProvider{
+		present: func(domain, token, keyAuth string) error { return nil },
+	})
+	err := withChallengeServers("obtaining cert", func() error {
+		if err := p.Present("example.com", "token", "keyAuth"); err != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+		if err := p.CleanUp("example.com", "token", "keyAuth"); err != nil {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the CA had valid authorizations already
+	err = withChallengeServers("renewing cert", func() error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(timings) != 2 {
+		t.Fatalf("expected 2 reports, got %d", len(timings))
+	}
+	obtained := timings[0]
+	if obtained.Action != "obtaining cert" || obtained.Challenge < 20*time.Millisecond || obtained.Finalize < 10*time.Millisecond {
+		t.Errorf("unexpected timings %+v", obtained)
+	}
+	if obtained.Total < obtained.Challenge+obtained.Finalize {
+		t.Errorf("total %s is less than the sum of its parts", obtained.Total)
+	}
+	if renewed := timings[1]; renewed.Challenge != 0 || renewed.Finalize != renewed.Total {
+		t.Errorf("expected no challenge time without challenges, got %+v", renewed)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -52,18 +53,18 @@ func getUser() (SSLUser, error) {
 	var u SSLUser
 
 	// do we have a user?
-	b, err := os.ReadFile(filepath.Join(c.CacheDir, sslUserFileName))
+	b, err := os.ReadFile(filepath.Join(c.accountDir(), sslUserFileName))
 	if err == nil {
 		// user exists. load
 		err = sonnet.Unmarshal(b, &u)
 		if err != nil {
-			return u, fmt.Errorf("simplecert: failed to unmarshal SSLUser: %s", err)
+			return u, fmt.Errorf("simplecert: failed to unmarshal SSLUser: %w", err)
 		}
 	} else {
 		// create private key
 		privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 		if err != nil {
-			return u, fmt.Errorf("simplecert: failed to generate private key: %s", err)
+			return u, fmt.Errorf("simplecert: failed to generate private key: %w", err)
 		}
 
 		// Create new user
@@ -78,13 +79,82 @@ func getUser() (SSLUser, error) {
 
 // save the user on disk
 // fatals on error
-func saveUserToDisk(u SSLUser, cacheDir string) {
+func saveUserToDisk(u SSLUser, accountDir string) {
 	b, err := sonnet.MarshalIndent(u, "", "  ")
 	if err != nil {
 		log.Fatal("[FATAL] simplecert: failed to marshal user: ", err)
 	}
-	err = os.WriteFile(filepath.Join(c.CacheDir, sslUserFileName), b, c.CacheDirPerm)
+	err = os.MkdirAll(accountDir, c.CacheDirPerm)
+	if err != nil {
+		log.Fatal("[FATAL] simplecert: could not create account dir: ", err)
+	}
+	err = os.WriteFile(filepath.Join(accountDir, sslUserFileName), b, c.CacheDirPerm)
 	if err != nil {
 		log.Fatal("[FATAL] simplecert: failed to write user to disk: ", err)
 	}
 }
+
+// ExportAccount returns the ACME account stored in the AccountStorageDir or CacheDir of cfg as a portable JSON blob.
+// The blob contains the email, the registration resource and the private key of the account,
+// and can be restored on another machine with ImportAccount, to avoid registering a new account.
+// CAUTION: the returned data contains the private key of your account, store it safely!
+func ExportAccount(cfg *Config) ([]byte, error) {
+	b, err := os.ReadFile(filepath.Join(cfg.accountDir(), sslUserFileName))
+	if err != nil {
+		return nil, fmt.Errorf("simplecert: failed to read SSLUser from disk: %w", err)
+	}
+
+	u, err := parseAccount(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return sonnet.MarshalIndent(u, "", "  ")
+}
+
+// ImportAccount stores an ACME account previously exported with ExportAccount in the AccountStorageDir or CacheDir of cfg.
+// An existing account will be overwritten.
+func ImportAccount(cfg *Config, data []byte) error {
+	u, err := parseAccount(data)
+	if err != nil {
+		return err
+	}
+
+	b, err := sonnet.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to marshal user: %w", err)
+	}
+
+	err = os.MkdirAll(cfg.accountDir(), cfg.CacheDirPerm)
+	if err != nil {
+		return fmt.Errorf("simplecert: could not create account dir: %w", err)
+	}
+
+	err = writeFileAtomic(filepath.Join(cfg.accountDir(), sslUserFileName), b, cfg.CacheDirPerm)
+	if err != nil {
+		return fmt.Errorf("simplecert: failed to write user to disk: %w", err)
+	}
+
+	return nil
+}
+
+// parseAccount unmarshals an SSLUser and makes sure it is a complete, registered account
+func parseAccount(data []byte) (SSLUser, error) {
+	var u SSLUser
+	err := sonnet.Unmarshal(data, &u)
+	if err != nil {
+		return u, fmt.Errorf("simplecert: failed to unmarshal SSLUser: %w", err)
+	}
+
+	if u.Key == nil {
+		return u, errors.New("simplecert: account has no private key")
+	}
+	if err = u.Key.Validate(); err != nil {
+		return u, fmt.Errorf("simplecert: invalid account private key: %w", err)
+	}
+	if u.Registration == nil {
+		return u, errors.New("simplecert: account is not registered")
+	}
+
+	return u, nil
+}
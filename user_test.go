@@ -0,0 +1,71 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/sugawarayuuta/sonnet"
+)
+
+func TestExportImportAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := SSLUser{
+		Email:        "test@example.com",
+		Registration: &registration.Resource{URI: "https://ca.example.com/acme/acct/1"},
+		Key:          key,
+	}
+
+	src := &Config{CacheDir: t.TempDir(), CacheDirPerm: 0700}
+	setConfig(src)
+	defer setConfig(nil)
+	saveUserToDisk(u, src.accountDir())
+
+	data, err := ExportAccount(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restore the account on another machine
+	dst := &Config{CacheDir: t.TempDir(), CacheDirPerm: 0700}
+	if err = ImportAccount(dst, data); err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig(dst)
+	imported, err := getUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !imported.Key.Equal(key) {
+		t.Error("imported account has a different key")
+	}
+	if imported.Registration == nil || imported.Registration.URI != u.Registration.URI {
+		t.Errorf("imported account has registration %+v, want %s", imported.Registration, u.Registration.URI)
+	}
+	if imported.Email != u.Email {
+		t.Errorf("imported account has email %q, want %q", imported.Email, u.Email)
+	}
+
+	// an account with a broken key is rejected
+	u.Key = &rsa.PrivateKey{PublicKey: key.PublicKey, D: big.NewInt(1), Primes: key.Primes}
+	broken, err := sonnet.Marshal(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ImportAccount(&Config{CacheDir: t.TempDir(), CacheDirPerm: 0700}, broken)
+	if err == nil || !strings.Contains(err.Error(), "invalid account private key") {
+		t.Fatalf("expected an error for the invalid account key, got %v", err)
+	}
+}
@@ -11,16 +11,12 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/foomo/tlsconfig"
 )
 
-// internal date of the backup to allow restoring in case of an error
-// even if renewal happens just before midnight and restoring afterwards
-var backupDate string
-
 const localhost = "127.0.0.1"
 
 /*
@@ -154,12 +150,52 @@ func ensureCacheDirExists(cacheDir string) {
 	}
 }
 
-// runCommand executes the named command with the supplied arguments
-// and fatals on error
-func runCommand(cmd string, args ...string) {
-	out, err := exec.Command(cmd, args...).CombinedOutput()
+// copyFile atomically copies the file at src to dst, keeping its permissions
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dst, data, info.Mode().Perm())
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// and renames it into place once it has been flushed to disk.
+// The rename is atomic on POSIX filesystems, so concurrent readers
+// will either see the previous contents or the new ones, but never a truncated file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
-		log.Println("[ERROR] failed to run command: ", cmd+strings.Join(args, " "))
-		log.Fatal("[FATAL] simplecert: error: ", err, ", output: ", string(out))
+		return err
 	}
+	tmpPath := f.Name()
+
+	// remove the temp file if anything goes wrong, including the rename
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
 }
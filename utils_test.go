@@ -0,0 +1,34 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicRenameFails(t *testing.T) {
+	dir := t.TempDir()
+
+	// a file can not be renamed over a directory
+	path := filepath.Join(dir, keyFileName)
+	if err := os.Mkdir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("key"), 0600); err == nil {
+		t.Fatal("expected the rename to fail")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected the temp file to be removed, found %d entries", len(entries))
+	}
+}
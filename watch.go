@@ -0,0 +1,84 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// default interval for polling the cert and key files if WatchCertFiles is set
+var watchInterval = 10 * time.Second
+
+// fileVersion identifies the contents of a file by the file it resolves to, its modification time and size,
+// nil if the file does not exist
+type fileVersion struct {
+	info os.FileInfo
+}
+
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{info: info}
+}
+
+// same reports whether v and o are the same version of a file.
+// Files replaced by a rename or a symlink are detected even if modification time and size did not change.
+func (v fileVersion) same(o fileVersion) bool {
+	if v.info == nil || o.info == nil {
+		return v.info == nil && o.info == nil
+	}
+	return os.SameFile(v.info, o.info) && v.info.ModTime().Equal(o.info.ModTime()) && v.info.Size() == o.info.Size()
+}
+
+// watchCertFiles polls the cert and key files and reloads them when they have been changed on disk,
+// including symlinks that are pointed to new files, like the mounted secrets of Kubernetes,
+// until the reloader is closed. A pair that can not be loaded, e.g. because only one of the files
+// has been written so far, is ignored and retried on the next check.
+// cert and key are the versions of the files that have been loaded.
+func (reloader *CertReloader) watchCertFiles(cert, key fileVersion) {
+	interval := watchInterval
+	if reloader.cfg.WatchInterval > 0 {
+		interval = reloader.cfg.WatchInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-reloader.stop:
+			return
+		case <-ticker.C:
+		}
+
+		certPath, keyPath := reloader.paths()
+		newCert, newKey := statFile(certPath), statFile(keyPath)
+		if newCert.same(cert) && newKey.same(key) {
+			continue
+		}
+
+		// do not read the files while simplecert writes a new cert
+		diskMu.Lock()
+		err := reloader.loadFrom(certPath, keyPath)
+		diskMu.Unlock()
+		if err != nil {
+			log.Println("[WARNING] simplecert: cert files changed on disk, but can not be loaded yet: ", err)
+			continue
+		}
+
+		log.Println("[INFO] simplecert: reloaded cert files changed on disk")
+		cert, key = newCert, newKey
+
+		// the staple is fetched without holding diskMu, so writing a cert does not wait for the OCSP responder
+		if reloader.config().EnableOCSPStapling {
+			reloader.stapleOCSP()
+		}
+	}
+}
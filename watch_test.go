@@ -0,0 +1,107 @@
+// simplecert
+//
+// Created by Philipp Mieden
+// Contact: dreadl0ck@protonmail.ch
+// Copyright © 2018 bestbytes. All rights reserved.
+package simplecert
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchCertFiles(t *testing.T) {
+	setConfig(&Config{WatchCertFiles: true, DisableReloadSignal: true})
+	defer setConfig(nil)
+
+	interval := watchInterval
+	watchInterval = 10 * time.Millisecond
+	defer func() { watchInterval = interval }()
+
+	var (
+		dir      = t.TempDir()
+		certPath = filepath.Join(dir, certFileName)
+		keyPath  = filepath.Join(dir, keyFileName)
+	)
+	writePair := func(validity time.Duration) {
+		cert := testCertResource(t, validity)
+		if err := os.WriteFile(certPath, cert.Certificate, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyPath, cert.PrivateKey, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePair(time.Hour)
+	reloader, err := NewCertReloader(certPath, keyPath, nil, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	// replace the files out of band
+	old := reloader.NotAfter()
+	writePair(48 * time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reloader.NotAfter().Equal(old) {
+		if time.Now().After(deadline) {
+			t.Fatal("cert has not been reloaded after the files changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchCertFilesSymlinkSwap(t *testing.T) {
+	setConfig(&Config{WatchCertFiles: true, WatchInterval: 10 * time.Millisecond, DisableReloadSignal: true})
+	defer setConfig(nil)
+
+	// the layout of a mounted Kubernetes secret: the files link to ..data/,
+	// which links to a timestamped folder that is swapped on update
+	dir := t.TempDir()
+	writeVersion := func(name string, validity time.Duration) {
+		cert := testCertResource(t, validity)
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "tls.crt"), cert.Certificate, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "tls.key"), cert.PrivateKey, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(name, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeVersion("..v1", time.Hour)
+	for _, name := range []string{"tls.crt", "tls.key"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloader, err := NewCertReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), nil, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloader.Close()
+
+	old := reloader.NotAfter()
+	writeVersion("..v2", 48*time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for reloader.NotAfter().Equal(old) {
+		if time.Now().After(deadline) {
+			t.Fatal("cert has not been reloaded after the secret was updated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}